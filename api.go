@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// apiError 统一的 JSON 错误响应
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// validationError 植物字段校验失败, Fields 为字段名到错误原因的映射
+type validationError struct {
+	Fields map[string]string
+}
+
+func (e *validationError) Error() string {
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	msgs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		msgs = append(msgs, fmt.Sprintf("%s %s", k, e.Fields[k]))
+	}
+
+	return "validation failed: " + strings.Join(msgs, ", ")
+}
+
+// badRequestError 请求体无法解析
+type badRequestError struct {
+	err error
+}
+
+func (e *badRequestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *badRequestError) Unwrap() error {
+	return e.err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("encoding response error:", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string, details any) {
+	writeJSON(w, status, apiError{Code: code, Message: message, Details: details})
+}
+
+// writeStoreError 将存储和校验错误映射为对应的状态码
+func writeStoreError(w http.ResponseWriter, err error) {
+	var verr *validationError
+	var berr *badRequestError
+
+	switch {
+	case errors.As(err, &verr):
+		writeError(w, http.StatusUnprocessableEntity, "validation_failed", verr.Error(), verr.Fields)
+	case errors.As(err, &berr):
+		writeError(w, http.StatusBadRequest, "bad_request", berr.Error(), nil)
+	case errors.Is(err, errPlantNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error(), nil)
+	case errors.Is(err, errPlantExists), errors.Is(err, errPlantAmbiguous), errors.Is(err, errPlantInUse):
+		writeError(w, http.StatusConflict, "conflict", err.Error(), nil)
+	default:
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error(), nil)
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, &badRequestError{fmt.Errorf("reading body error: %w", err)}
+	}
+
+	return body, nil
+}
+
+func createPlant(ctx context.Context, body []byte) (*Plant, error) {
+	var plant Plant
+	if err := json.Unmarshal(body, &plant); err != nil {
+		return nil, &badRequestError{fmt.Errorf("unmarshalling json error: %w", err)}
+	}
+
+	// id 和 slug 由存储生成
+	plant.ID, plant.Slug = "", ""
+
+	derive(&plant)
+	if err := validate(&plant); err != nil {
+		return nil, err
+	}
+	localizeImages(ctx, &plant)
+
+	log.Printf("adding plant %v with name %s\n", plant, plant.Cnname)
+
+	if err := store.Put(&plant); err != nil {
+		return nil, err
+	}
+
+	return &plant, nil
+}
+
+// updatePlant 修改 key (id, slug 或名称) 对应的植物, replace 为 true 时整体替换, 否则按 JSON merge patch (RFC 7396) 合并
+func updatePlant(ctx context.Context, key string, body []byte, replace bool) (*Plant, error) {
+	old, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	preview, err := applyUpdate(old, body, replace)
+	if err != nil {
+		return nil, err
+	}
+
+	// 镜像远程图片较慢, 先在锁外完成, 合并时再按地址替换
+	mirrored := map[string]string{}
+	localizeImages(ctx, preview)
+	for local, src := range preview.Origins {
+		mirrored[src] = local
+	}
+
+	log.Printf("updating plant %s to %v\n", key, preview)
+
+	// 在存储锁内基于最新数据重新合并, 避免覆盖并发修改
+	return store.Modify(key, func(p *Plant) error {
+		plant, err := applyUpdate(p, body, replace)
+		if err != nil {
+			return err
+		}
+		replaceImages(plant, func(src string) string {
+			if local, ok := mirrored[src]; ok {
+				return local
+			}
+			return src
+		})
+		*p = *plant
+		return nil
+	})
+}
+
+// applyUpdate 将请求体应用到 old 上, 返回派生并校验后的新植物, old 保持不变
+func applyUpdate(old *Plant, body []byte, replace bool) (*Plant, error) {
+	var plant Plant
+	if replace {
+		if err := json.Unmarshal(body, &plant); err != nil {
+			return nil, &badRequestError{fmt.Errorf("unmarshalling json error: %w", err)}
+		}
+		// 未提交候选图片时保留原有缓存
+		if plant.Images == nil {
+			plant.Images = old.Images
+		}
+		if plant.Origins == nil {
+			plant.Origins = old.Origins
+		}
+		if plant.Photos == nil {
+			plant.Photos = old.Photos
+		}
+	} else {
+		orig, err := json.Marshal(old)
+		if err != nil {
+			return nil, err
+		}
+		merged, err := mergePatch(orig, body)
+		if err != nil {
+			return nil, &badRequestError{fmt.Errorf("applying merge patch error: %w", err)}
+		}
+		if err := json.Unmarshal(merged, &plant); err != nil {
+			return nil, &badRequestError{fmt.Errorf("unmarshalling json error: %w", err)}
+		}
+	}
+
+	derive(&plant)
+	if err := validate(&plant); err != nil {
+		return nil, err
+	}
+	return &plant, nil
+}
+
+// mergePatch 按 RFC 7396 将 patch 合并到 target, null 表示删除字段
+func mergePatch(target, patch []byte) ([]byte, error) {
+	var t, p any
+	if err := json.Unmarshal(target, &t); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergeValue(t, p))
+}
+
+func mergeValue(target, patch any) any {
+	pm, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	tm, ok := target.(map[string]any)
+	if !ok {
+		tm = map[string]any{}
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(tm, k)
+		} else {
+			tm[k] = mergeValue(tm[k], v)
+		}
+	}
+
+	return tm
+}
+
+func listPlantsHandler(w http.ResponseWriter, r *http.Request) {
+	plants, err := store.List()
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, plants)
+}
+
+func getPlantHandler(w http.ResponseWriter, r *http.Request) {
+	plant, err := store.Get(r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, plant)
+}
+
+func createPlantHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	plant, err := createPlant(r.Context(), body)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, plant)
+}
+
+func updatePlantHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	plant, err := updatePlant(r.Context(), r.PathValue("id"), body, r.Method == http.MethodPut)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, plant)
+}
+
+// deletePlantHandler 删除植物及其养护日志, 仍被收藏引用的植物不能删除
+func deletePlantHandler(w http.ResponseWriter, r *http.Request) {
+	plant, err := store.Get(r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if inUse, err := plantInUse(plant.ID); err != nil || inUse {
+		if err == nil {
+			err = errPlantInUse
+		}
+		writeStoreError(w, err)
+		return
+	}
+
+	if err := store.Delete(plant.ID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if err := journal.Purge(plant.ID, ""); err != nil {
+		log.Printf("failed to purge journal of plant %s: %v\n", plant.ID, err)
+	}
+	if err := cares.Forget(plant.ID); err != nil {
+		log.Printf("failed to forget care log of plant %s: %v\n", plant.ID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookupHandler 通过大模型查询植物信息, 不写入目录
+func lookupHandler(w http.ResponseWriter, r *http.Request) {
+	pname := strings.TrimSpace(r.PathValue("name"))
+	if pname == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "please input plant name", nil)
+		return
+	}
+
+	if refresh, _ := strconv.ParseBool(r.URL.Query().Get("refresh")); refresh {
+		cache.Invalidate(pname)
+	}
+
+	pls, err := fetchInfo(r.Context(), pname)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, lookupError(err))
+		return
+	}
+	if len(pls) == 0 {
+		writeError(w, http.StatusNotFound, "not_found", "no plant found", map[string]string{"name": pname})
+		return
+	}
+
+	log.Printf("found plant %v with name %s\n", *pls[0], pname)
+
+	writeJSON(w, http.StatusOK, pls[0])
+}
+
+// lookupError 将大模型查询错误转换为响应体, 校验失败和 provider 失败分别附带字段和各 provider 的错误
+func lookupError(err error) apiError {
+	var verr *validationError
+	var ferr *failoverError
+	switch {
+	case errors.As(err, &verr):
+		return apiError{Code: "lookup_invalid", Message: err.Error(), Details: verr.Fields}
+	case errors.As(err, &ferr):
+		return apiError{Code: "lookup_failed", Message: err.Error(), Details: ferr.Errors}
+	}
+
+	return apiError{Code: "lookup_failed", Message: err.Error()}
+}
+
+func cacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, cache.Stats())
+}
+
+// invalidateCacheHandler 删除指定名称的缓存, 未指定名称时清空全部缓存
+func invalidateCacheHandler(w http.ResponseWriter, r *http.Request) {
+	var n int
+	if name := r.PathValue("name"); name != "" {
+		n = cache.Invalidate(name)
+	} else {
+		n = cache.Clear()
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"removed": n})
+}
+
+func registerAPI(mux *http.ServeMux) {
+	if media != nil {
+		mux.Handle("GET /media/", mediaHandler(media.dir))
+	}
+	mux.HandleFunc("GET /api/v1/plants", listPlantsHandler)
+	mux.HandleFunc("POST /api/v1/plants", createPlantHandler)
+	mux.HandleFunc("GET /api/v1/plants/{id}", getPlantHandler)
+	mux.HandleFunc("PUT /api/v1/plants/{id}", updatePlantHandler)
+	mux.HandleFunc("PATCH /api/v1/plants/{id}", updatePlantHandler)
+	mux.HandleFunc("DELETE /api/v1/plants/{id}", deletePlantHandler)
+	mux.HandleFunc("POST /api/v1/plants/{id}/photos", uploadPhotosHandler)
+	mux.HandleFunc("GET /api/v1/plants/{id}/care", plantCareHandler)
+	mux.HandleFunc("POST /api/v1/plants/{id}/care/{kind}/done", careDoneHandler)
+	mux.HandleFunc("GET /api/v1/care/tasks", careTasksHandler)
+	mux.HandleFunc("GET /api/v1/collection", listCollectionHandler)
+	mux.HandleFunc("POST /api/v1/collection", createSpecimenHandler)
+	mux.HandleFunc("GET /api/v1/collection/{id}", getSpecimenHandler)
+	mux.HandleFunc("PUT /api/v1/collection/{id}", updateSpecimenHandler)
+	mux.HandleFunc("PATCH /api/v1/collection/{id}", updateSpecimenHandler)
+	mux.HandleFunc("DELETE /api/v1/collection/{id}", deleteSpecimenHandler)
+	mux.HandleFunc("POST /api/v1/collection/{id}/photos", uploadSpecimenPhotosHandler)
+	mux.HandleFunc("GET /api/v1/journal", listJournalHandler)
+	mux.HandleFunc("POST /api/v1/journal", addJournalHandler)
+	mux.HandleFunc("GET /api/v1/journal/adherence", journalAdherenceHandler)
+	mux.HandleFunc("DELETE /api/v1/journal/{id}", deleteJournalHandler)
+	mux.HandleFunc("GET /api/v1/search", searchHandler)
+	mux.HandleFunc("GET /api/v1/catalog", catalogHandler)
+	mux.HandleFunc("GET /api/v1/calendar/{name}", calendarHandler)
+	mux.HandleFunc("GET /api/v1/flowering", floweringHandler)
+	mux.HandleFunc("GET /api/v1/safety", safetyHandler)
+	mux.HandleFunc("GET /api/v1/lookup/{name}", lookupHandler)
+	mux.HandleFunc("GET /api/v1/lookup/{name}/stream", streamLookupHandler)
+	mux.HandleFunc("POST /api/v1/lookup", batchLookupHandler)
+	mux.HandleFunc("GET /api/v1/cache", cacheStatsHandler)
+	mux.HandleFunc("DELETE /api/v1/cache", invalidateCacheHandler)
+	mux.HandleFunc("DELETE /api/v1/cache/{name}", invalidateCacheHandler)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultBatchChunk = 5
+	maxBatchNames     = 50
+)
+
+type batchRequest struct {
+	Names  []string `json:"names"`
+	Chunk  int      `json:"chunk,omitempty"`  // 每次大模型请求包含的植物数, 默认 5
+	Add    bool     `json:"add,omitempty"`    // 查询成功的植物直接加入目录
+	Images *bool    `json:"images,omitempty"` // 是否抓取候选图片, 默认抓取
+}
+
+// batchResult 单个名称的查询结果, Status 为 ok, not_found, failed, added, exists 或 invalid
+type batchResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Plant  *Plant `json:"plant,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// enrichPlants 整理大模型返回的植物并抓取候选图片
+func enrichPlants(ctx context.Context, pls []*Plant, images bool) {
+	for _, plant := range pls {
+		plant.Category = strings.ReplaceAll(plant.Category, " ", "")
+		if images {
+			plant.Images = fetchPlantImages(ctx, plant, nil)
+		}
+	}
+}
+
+// matchPlants 将一次请求返回的植物对应回查询名称: 优先按中英文名匹配, 数量一致时按顺序对应
+func matchPlants(names []string, pls []*Plant) map[string]*Plant {
+	res := map[string]*Plant{}
+	used := map[*Plant]bool{}
+
+	for _, name := range names {
+		norm := normalizeName(name)
+		for _, p := range pls {
+			if !used[p] && (normalizeName(p.Cnname) == norm || normalizeName(p.Enname) == norm) {
+				res[name] = p
+				used[p] = true
+				break
+			}
+		}
+	}
+
+	if len(pls) == len(names) {
+		for i, name := range names {
+			if _, ok := res[name]; !ok && !used[pls[i]] {
+				res[name] = pls[i]
+				used[pls[i]] = true
+			}
+		}
+	}
+
+	return res
+}
+
+// batchLookup 批量查询植物, 已缓存的名称直接返回, 其余按 chunk 分组请求大模型
+func batchLookup(ctx context.Context, names []string, chunk int, images bool) []*batchResult {
+	if chunk <= 0 {
+		chunk = defaultBatchChunk
+	}
+
+	results := make([]*batchResult, 0, len(names))
+	var pending []*batchResult
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[normalizeName(name)] {
+			continue
+		}
+		seen[normalizeName(name)] = true
+
+		res := &batchResult{Name: name}
+		results = append(results, res)
+
+		if pls, ok := cache.Get(name); ok && len(pls) > 0 {
+			res.Status, res.Plant = "ok", pls[0]
+			continue
+		}
+		pending = append(pending, res)
+	}
+
+	for start := 0; start < len(pending); start += chunk {
+		group := pending[start:min(start+chunk, len(pending))]
+		query := make([]string, 0, len(group))
+		for _, res := range group {
+			query = append(query, res.Name)
+		}
+
+		if err := lookupChunk(ctx, group); err != nil {
+			log.Printf("batch lookup %v error: %v\n", query, err)
+			for _, res := range group {
+				res.Status, res.Error = "failed", err.Error()
+			}
+			continue
+		}
+
+		for _, res := range group {
+			if res.Status != "" {
+				continue
+			}
+			if res.Plant == nil {
+				res.Status, res.Error = "not_found", "no plant found"
+				continue
+			}
+
+			enrichPlants(ctx, []*Plant{res.Plant}, images)
+			// 不抓取图片时的结果不完整, 不写入缓存, 以免之后的查询拿到没有候选图片的植物
+			if images {
+				cache.Put(res.Name, []*Plant{res.Plant})
+			}
+			res.Status = "ok"
+		}
+	}
+
+	return results
+}
+
+// lookupChunk 一次请求查询一组植物, 找到的植物填入 Plant. 回答逐项校验, 通过校验的直接采用,
+// 未通过的单独反馈给模型修正, 回答中缺失的名称单独重新查询; 单项失败时设置其 Status 和 Error
+func lookupChunk(ctx context.Context, group []*batchResult) error {
+	query := make([]string, 0, len(group))
+	for _, res := range group {
+		query = append(query, res.Name)
+	}
+	question := strings.Join(query, "\n")
+	log.Printf("retrieving %s plant information with llm %s\n", question, llm.Name())
+
+	req := chatRequest{
+		System:   systemPrompt,
+		Messages: []chatMessage{{Role: "user", Content: question}},
+		Schema:   lookupSchema(),
+	}
+	content, err := llm.Chat(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	pls, err := parsePlants(content)
+	valid, invalid := pls, []*Plant(nil)
+	if err != nil {
+		log.Printf("llm answer for %v failed validation, repairing each plant: %v\n", query, err)
+
+		valid = nil
+		for _, p := range pls {
+			if p == nil {
+				continue
+			}
+			if len(validateLookup(p)) == 0 {
+				valid = append(valid, p)
+			} else {
+				invalid = append(invalid, p)
+			}
+		}
+	}
+	matched, broken := matchPlants(query, valid), matchPlants(query, invalid)
+
+	for _, res := range group {
+		if p, ok := matched[res.Name]; ok {
+			res.Plant = p
+			continue
+		}
+
+		var fixed []*Plant
+		if p, ok := broken[res.Name]; ok {
+			var data []byte
+			if data, err = json.Marshal(p); err != nil {
+				res.Status, res.Error = "failed", err.Error()
+				continue
+			}
+			req := chatRequest{
+				System: systemPrompt,
+				Messages: []chatMessage{
+					{Role: "user", Content: res.Name},
+					{Role: "assistant", Content: string(data)},
+					{Role: "user", Content: repairPrompt(&validationError{Fields: validateLookup(p)})},
+				},
+				Schema: lookupSchema(),
+			}
+			fixed, err = chatPlants(ctx, req, res.Name, nil)
+		} else {
+			fixed, err = reqAI(ctx, res.Name, nil)
+		}
+		if err != nil {
+			res.Status, res.Error = "failed", err.Error()
+			continue
+		}
+		if len(fixed) > 0 {
+			res.Plant = fixed[0]
+		}
+	}
+
+	return nil
+}
+
+// addBatchResults 将查询成功的植物加入目录, 并更新各自的状态
+func addBatchResults(ctx context.Context, results []*batchResult) {
+	for _, res := range results {
+		if res.Status != "ok" {
+			continue
+		}
+
+		p := *res.Plant
+		p.ID, p.Slug = "", ""
+		if p.Image == "" && len(p.Images) > 0 {
+			p.Image = p.Images[0]
+		}
+
+		derive(&p)
+		if err := validate(&p); err != nil {
+			res.Status, res.Error = "invalid", err.Error()
+			continue
+		}
+		localizeImages(ctx, &p)
+
+		if err := store.Put(&p); err != nil {
+			if errors.Is(err, errPlantExists) {
+				res.Status, res.Error = "exists", err.Error()
+			} else {
+				res.Status, res.Error = "failed", err.Error()
+			}
+			continue
+		}
+
+		res.Status, res.Plant = "added", &p
+	}
+}
+
+func batchLookupHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var req batchRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("unmarshalling json error: %v", err), nil)
+		return
+	}
+	if len(req.Names) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "validation_failed", "names is required", map[string]string{"names": "is required"})
+		return
+	}
+	if len(req.Names) > maxBatchNames {
+		writeError(w, http.StatusUnprocessableEntity, "validation_failed", fmt.Sprintf("at most %d names per request", maxBatchNames), map[string]string{"names": "too many"})
+		return
+	}
+
+	images := req.Images == nil || *req.Images
+	results := batchLookup(r.Context(), req.Names, req.Chunk, images)
+	if req.Add {
+		addBatchResults(r.Context(), results)
+	}
+
+	summary := map[string]int{}
+	for _, res := range results {
+		summary[res.Status]++
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"results": results, "summary": summary})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+var errBrowserClosed = errors.New("browser pool closed")
+
+// browserPool 复用同一个 headless Chrome 进程, 以有限数量的标签页渲染网页
+type browserPool struct {
+	execPath string
+	flags    map[string]any
+	timeout  time.Duration
+
+	mu            sync.Mutex
+	closed        bool
+	allocCancel   context.CancelFunc
+	browserCtx    context.Context
+	browserCancel context.CancelFunc
+
+	sem  chan struct{}    // 限制同时使用的标签页数
+	idle chan *browserTab // 空闲的标签页, 下次渲染时复用
+}
+
+type browserTab struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	browser context.Context // 所属的浏览器, 浏览器重启后旧标签页作废
+}
+
+var browsers *browserPool
+
+// parseChromeFlags 解析 "--no-sandbox --proxy-server=http://127.0.0.1:7897" 形式的启动参数
+func parseChromeFlags(s string) map[string]any {
+	flags := map[string]any{}
+	for _, f := range strings.Fields(s) {
+		name, val, ok := strings.Cut(strings.TrimLeft(f, "-"), "=")
+		switch {
+		case !ok || val == "true":
+			flags[name] = true
+		case val == "false":
+			flags[name] = false
+		default:
+			flags[name] = val
+		}
+	}
+
+	return flags
+}
+
+// newBrowserPool 创建浏览器池, 浏览器在第一次渲染时才启动
+func newBrowserPool(execPath string, flags map[string]any, tabs int, timeout time.Duration) *browserPool {
+	tabs = max(tabs, 1)
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &browserPool{
+		execPath: execPath,
+		flags:    flags,
+		timeout:  timeout,
+		sem:      make(chan struct{}, tabs),
+		idle:     make(chan *browserTab, tabs),
+	}
+}
+
+// browser 返回正在运行的浏览器, 尚未启动或已退出时重新启动
+func (b *browserPool) browser() (context.Context, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, errBrowserClosed
+	}
+	if b.browserCtx != nil && b.browserCtx.Err() == nil {
+		return b.browserCtx, nil
+	}
+	if b.allocCancel != nil {
+		b.browserCancel()
+		b.allocCancel()
+	}
+
+	options := []chromedp.ExecAllocatorOption{
+		chromedp.NoDefaultBrowserCheck,
+		chromedp.Flag("headless", true), // debug使用
+		chromedp.Flag("blink-settings", "imagesEnabled=true"),
+		chromedp.Flag("ignore-certificate-errors", true),
+		chromedp.Flag("disable-dev-shm-usage", true), // 容器中 /dev/shm 通常很小
+		chromedp.UserAgent(`Mozilla/5.0 (Windows NT 6.3; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/73.0.3683.103 Safari/537.36`),
+	}
+	// root 用户 (如容器中) 无法启用 Chrome 沙箱
+	if os.Geteuid() == 0 {
+		options = append(options, chromedp.NoSandbox)
+	}
+	if b.execPath != "" {
+		options = append(options, chromedp.ExecPath(b.execPath))
+	}
+	for name, val := range b.flags {
+		options = append(options, chromedp.Flag(name, val))
+	}
+	options = append(chromedp.DefaultExecAllocatorOptions[:], options...)
+
+	allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), options...)
+	// chromedp 对不认识的协议事件会打印大量错误日志, 不影响渲染结果, 直接忽略
+	browserCtx, browserCancel := chromedp.NewContext(allocCtx, chromedp.WithErrorf(func(string, ...any) {}))
+	// 不带任何动作执行一次即启动浏览器
+	if err := chromedp.Run(browserCtx); err != nil {
+		browserCancel()
+		allocCancel()
+		return nil, err
+	}
+
+	log.Println("headless browser started")
+	b.allocCancel, b.browserCtx, b.browserCancel = allocCancel, browserCtx, browserCancel
+
+	return browserCtx, nil
+}
+
+// acquire 获取一个标签页, 优先复用空闲标签页, 标签页数达到上限时等待
+func (b *browserPool) acquire(ctx context.Context) (*browserTab, error) {
+	select {
+	case b.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	browserCtx, err := b.browser()
+	if err != nil {
+		<-b.sem
+		return nil, err
+	}
+
+	for {
+		select {
+		case tab := <-b.idle:
+			if tab.browser == browserCtx && tab.ctx.Err() == nil {
+				return tab, nil
+			}
+			tab.cancel()
+		default:
+			// 第一次 Run 会创建标签页, 标签页的生命周期与这次 Run 的 context 绑定,
+			// 因此在这里用不带超时的 tabCtx 创建, 之后的导航再各自加上超时
+			tabCtx, cancel := chromedp.NewContext(browserCtx)
+			if err := chromedp.Run(tabCtx); err != nil {
+				cancel()
+				<-b.sem
+				return nil, err
+			}
+			return &browserTab{ctx: tabCtx, cancel: cancel, browser: browserCtx}, nil
+		}
+	}
+}
+
+// release 归还标签页, 出错的标签页直接关闭, 避免复用到异常状态
+func (b *browserPool) release(tab *browserTab, healthy bool) {
+	defer func() { <-b.sem }()
+
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+
+	if !healthy || closed {
+		tab.cancel()
+		return
+	}
+
+	select {
+	case b.idle <- tab:
+	default:
+		tab.cancel()
+	}
+}
+
+// Render 打开网页并等待 selector 可见后返回页面 html, 单个页面最长等待 timeout
+func (b *browserPool) Render(ctx context.Context, urlstr, selector string) (string, error) {
+	tab, err := b.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	runCtx, cancel := context.WithTimeout(tab.ctx, b.timeout)
+	defer cancel()
+	// 调用方取消时 (如客户端断开) 同时中止渲染
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	var htmlContent string
+	err = chromedp.Run(runCtx,
+		chromedp.Navigate(urlstr),
+		chromedp.WaitVisible(selector),
+		chromedp.OuterHTML("html", &htmlContent),
+	)
+	b.release(tab, err == nil)
+	if err != nil {
+		return "", err
+	}
+
+	return htmlContent, nil
+}
+
+// Close 关闭所有标签页并退出浏览器进程
+func (b *browserPool) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for len(b.idle) > 0 {
+		(<-b.idle).cancel()
+	}
+
+	if b.allocCancel != nil {
+		b.browserCancel()
+		b.allocCancel()
+		log.Println("headless browser stopped")
+	}
+}
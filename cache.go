@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// cacheEntry 一次大模型查询的结果, 包含已抓取的候选图片
+type cacheEntry struct {
+	Name      string    `json:"name"`
+	Version   string    `json:"version"`
+	Plants    []*Plant  `json:"plants"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// lookupCache 以规范化植物名和提示词/模型版本为键的查询缓存, 持久化到 json 文件
+type lookupCache struct {
+	mu      sync.Mutex
+	path    string
+	ttl     time.Duration
+	version string
+	entries map[string]*cacheEntry
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+var cache *lookupCache
+
+// normalizeName 统一大小写, 全角空格和多余空白, 使 "Lily of the valley " 与 "lily of the valley" 命中同一条缓存
+func normalizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		return unicode.ToLower(r)
+	}, name)
+
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// modelVersion 提示词, schema 和模型配置的摘要, 任何一项变化都会使旧缓存失效
+func modelVersion(cfgs []providerConfig) string {
+	h := sha256.New()
+	h.Write([]byte(systemPrompt))
+	schema, _ := json.Marshal(lookupSchema())
+	h.Write(schema)
+	for _, cfg := range cfgs {
+		fmt.Fprintf(h, "|%s|%s|%s", cfg.Type, cfg.URL, cfg.Model)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+func openLookupCache(path string, ttl time.Duration, version string) *lookupCache {
+	c := &lookupCache{path: path, ttl: ttl, version: version, entries: map[string]*cacheEntry{}}
+	if path == "" {
+		return c
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("failed to read lookup cache:", err)
+		}
+		return c
+	}
+
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		log.Println("failed to unmarshal lookup cache:", err)
+		c.entries = map[string]*cacheEntry{}
+	}
+
+	return c
+}
+
+func (c *lookupCache) key(name string) string {
+	return normalizeName(name) + "|" + c.version
+}
+
+func (c *lookupCache) valid(e *cacheEntry) bool {
+	return e.Version == c.version && (c.ttl <= 0 || time.Since(e.CreatedAt) < c.ttl)
+}
+
+// clone 深拷贝, 调用方修改副本 (如 derive 整理毒性) 不影响原植物
+func (p *Plant) clone() *Plant {
+	plant := *p
+	plant.Images = slices.Clone(p.Images)
+	plant.Origins = maps.Clone(p.Origins)
+	plant.Photos = slices.Clone(p.Photos)
+	if p.Toxicology != nil {
+		t := *p.Toxicology
+		t.Parts, t.Symptoms = slices.Clone(t.Parts), slices.Clone(t.Symptoms)
+		plant.Toxicology = &t
+	}
+	if p.SizeRange != nil {
+		r := *p.SizeRange
+		plant.SizeRange = &r
+	}
+	if p.TempRange != nil {
+		r := *p.TempRange
+		plant.TempRange = &r
+	}
+	if p.TempLimits != nil {
+		r := *p.TempLimits
+		plant.TempLimits = &r
+	}
+	plant.Months = slices.Clone(p.Months)
+	plant.Unparsed = slices.Clone(p.Unparsed)
+
+	return &plant
+}
+
+func clonePlants(pls []*Plant) []*Plant {
+	res := make([]*Plant, 0, len(pls))
+	for _, p := range pls {
+		res = append(res, p.clone())
+	}
+
+	return res
+}
+
+func (c *lookupCache) Get(name string) ([]*Plant, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[c.key(name)]
+	if !ok || !c.valid(e) {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.hits.Add(1)
+	return clonePlants(e.Plants), true
+}
+
+// Put 缓存查询结果, 单个植物同时以其中文名和英文名作为键, 换一种名称查询也能命中
+func (c *lookupCache) Put(name string, pls []*Plant) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	names := []string{name}
+	if len(pls) == 1 {
+		names = append(names, pls[0].Cnname, pls[0].Enname)
+	}
+	for _, n := range names {
+		if normalizeName(n) == "" {
+			continue
+		}
+		c.entries[c.key(n)] = &cacheEntry{Name: n, Version: c.version, Plants: clonePlants(pls), CreatedAt: now}
+	}
+
+	c.save()
+}
+
+// Invalidate 删除名称对应的缓存 (不区分版本), 包括以该植物其他名称缓存的条目, 返回删除的条数
+func (c *lookupCache) Invalidate(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	norm := normalizeName(name)
+	n := 0
+	for k, e := range c.entries {
+		if strings.HasPrefix(k, norm+"|") || slices.ContainsFunc(e.Plants, func(p *Plant) bool {
+			return normalizeName(p.Cnname) == norm || normalizeName(p.Enname) == norm
+		}) {
+			delete(c.entries, k)
+			n++
+		}
+	}
+	if n > 0 {
+		c.save()
+	}
+
+	return n
+}
+
+func (c *lookupCache) Clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(c.entries)
+	c.entries = map[string]*cacheEntry{}
+	c.save()
+
+	return n
+}
+
+type cacheStats struct {
+	Entries int     `json:"entries"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+	TTL     string  `json:"ttl"`
+	Version string  `json:"version"`
+}
+
+func (c *lookupCache) Stats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := cacheStats{
+		Entries: len(c.entries),
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		TTL:     c.ttl.String(),
+		Version: c.version,
+	}
+	if total := st.Hits + st.Misses; total > 0 {
+		st.HitRate = float64(st.Hits) / float64(total)
+	}
+
+	return st
+}
+
+// save 清理过期条目后写入文件, 调用方需持有锁
+func (c *lookupCache) save() {
+	for k, e := range c.entries {
+		if !c.valid(e) {
+			delete(c.entries, k)
+		}
+	}
+
+	if c.path == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		log.Println("failed to marshal lookup cache:", err)
+		return
+	}
+	if err := writeFileAtomic(c.path, data, nil); err != nil {
+		log.Println("failed to save lookup cache:", err)
+	}
+}
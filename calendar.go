@@ -0,0 +1,339 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const careFlowering = "flowering"
+
+// calendarUser 一个日历订阅者, 订阅地址为 /api/v1/calendar/{name}.ics?token=...
+type calendarUser struct {
+	Name       string   `json:"name"`
+	Token      string   `json:"token"`      // 为空时无需令牌
+	Kinds      []string `json:"kinds"`      // watering, fertilization, flowering, 为空时全部
+	Collection bool     `json:"collection"` // 只订阅我的植物, 每株单独提醒
+	Locations  []string `json:"locations"`  // 只订阅这些位置的植物, 隐含 collection
+	Plants     []string `json:"plants"`     // 只订阅这些植物 (id, slug 或名称)
+	Todo       bool     `json:"todo"`       // 浇水施肥输出为 VTODO 而不是 VEVENT
+	Hour       int      `json:"hour"`       // 提醒时间 (当天几点), 默认 9 点
+}
+
+type calendarConfig struct {
+	Users []calendarUser `json:"users"`
+}
+
+// defaultCalendarUser 没有日历配置时的默认订阅者, 订阅整个植物目录
+var defaultCalendarUser = calendarUser{Name: "plants", Hour: 9}
+
+var calendarUsers = []calendarUser{defaultCalendarUser}
+
+// loadCalendarConfig 读取日历订阅者配置, path 为空时只有默认订阅者
+func loadCalendarConfig(path string) ([]calendarUser, error) {
+	if path == "" {
+		return []calendarUser{defaultCalendarUser}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read calendar config: %w", err)
+	}
+	var cfg calendarConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal calendar config: %w", err)
+	}
+
+	seen := map[string]bool{}
+	for i := range cfg.Users {
+		u := &cfg.Users[i]
+		if u.Name == "" || strings.ContainsAny(u.Name, "/.") {
+			return nil, fmt.Errorf("invalid calendar user name: %q", u.Name)
+		}
+		if seen[u.Name] {
+			return nil, fmt.Errorf("duplicate calendar user: %s", u.Name)
+		}
+		seen[u.Name] = true
+		for _, k := range u.Kinds {
+			if !slices.Contains(careKinds, k) && k != careFlowering {
+				return nil, fmt.Errorf("calendar user %s: unknown kind %s", u.Name, k)
+			}
+		}
+		if u.Hour <= 0 || u.Hour > 23 {
+			u.Hour = defaultCalendarUser.Hour
+		}
+	}
+
+	return cfg.Users, nil
+}
+
+func (u *calendarUser) wants(kind string) bool {
+	return len(u.Kinds) == 0 || slices.Contains(u.Kinds, kind)
+}
+
+// calendarSubject 日历中的一个提醒对象: 目录中的植物, 或订阅我的植物时的一株
+type calendarSubject struct {
+	UID   string // 用于生成稳定的 UID, 日历应用据此更新而不是重复添加
+	Name  string
+	Plant *Plant
+}
+
+// calendarSubjects 按订阅者的过滤条件列出需要提醒的植物, 已删除的植物跳过, 不影响其余提醒
+func calendarSubjects(u *calendarUser) ([]calendarSubject, error) {
+	var plantIDs []string
+	for _, key := range u.Plants {
+		p, err := store.Get(key)
+		if errors.Is(err, errPlantNotFound) {
+			log.Printf("calendar user %s: skipping plant %s: %v\n", u.Name, key, err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("calendar user %s: %s: %w", u.Name, key, err)
+		}
+		plantIDs = append(plantIDs, p.ID)
+	}
+	// 配置的植物都已删除时不能当作未过滤
+	filtered := len(u.Plants) > 0
+
+	var subjects []calendarSubject
+	if !u.Collection && len(u.Locations) == 0 {
+		plants, err := store.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range plants {
+			if !filtered || slices.Contains(plantIDs, p.ID) {
+				subjects = append(subjects, calendarSubject{UID: p.ID, Name: p.Cnname, Plant: p})
+			}
+		}
+		return subjects, nil
+	}
+
+	specimens, err := collection.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range specimens {
+		if (filtered && !slices.Contains(plantIDs, s.PlantID)) || (len(u.Locations) > 0 && !slices.Contains(u.Locations, s.Location)) {
+			continue
+		}
+		p, err := store.Get(s.PlantID)
+		if errors.Is(err, errPlantNotFound) {
+			log.Printf("calendar user %s: skipping specimen %s: plant %s: %v\n", u.Name, s.ID, s.PlantID, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := s.Nickname
+		if s.Location != "" {
+			name = s.Location + " " + name
+		}
+		subjects = append(subjects, calendarSubject{UID: s.ID, Name: name, Plant: p})
+	}
+
+	return subjects, nil
+}
+
+// icsWriter 按 RFC 5545 输出日历, 转义文本并将长行折叠为不超过 75 字节
+type icsWriter struct {
+	b strings.Builder
+}
+
+func (w *icsWriter) line(name, value string) {
+	s := name + ":" + value
+	// 续行以空格开头, 占用一个字节
+	for limit := 75; len(s) > limit; limit = 74 {
+		n := limit
+		for !utf8.RuneStart(s[n]) {
+			n--
+		}
+		w.b.WriteString(s[:n] + "\r\n ")
+		s = s[n:]
+	}
+	w.b.WriteString(s + "\r\n")
+}
+
+func (w *icsWriter) text(name, value string) {
+	w.line(name, strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(value))
+}
+
+func icsDate(t time.Time) string {
+	return t.Format("20060102")
+}
+
+// icsByMonth 生成 RRULE 的 BYMONTH 部分, 全年时为空
+func icsByMonth(months []int) string {
+	if len(months) == 0 || len(months) == 12 {
+		return ""
+	}
+	parts := make([]string, len(months))
+	for i, m := range months {
+		parts[i] = strconv.Itoa(m)
+	}
+
+	return ";BYMONTH=" + strings.Join(parts, ",")
+}
+
+// writeCare 为一种养护输出重复提醒: 按月份的间隔分组, 每组一条 FREQ=DAILY 的规则, 从下一次到期日开始
+func (w *icsWriter) writeCare(u *calendarUser, sub calendarSubject, s careSchedule, today time.Time, stamp string) {
+	groups := map[int][]int{}
+	var intervals []int
+	for m := 1; m <= 12; m++ {
+		n := s.IntervalIn(time.Month(m))
+		if n <= 0 {
+			continue
+		}
+		if groups[n] == nil {
+			intervals = append(intervals, n)
+		}
+		groups[n] = append(groups[n], m)
+	}
+
+	var due time.Time
+	if next, ok := s.NextDue(cares.Last(sub.Plant.ID, s.Kind), today); ok {
+		due = next
+	}
+	action := map[string]string{careWatering: "浇水", careFertilization: "施肥"}[s.Kind]
+
+	for _, n := range intervals {
+		months := groups[n]
+		start := due
+		if start.IsZero() || start.Before(today) {
+			start = today
+		}
+		// 第一次提醒落在该组的月份内
+		for !slices.Contains(months, int(start.Month())) {
+			start = time.Date(start.Year(), start.Month()+1, 1, 0, 0, 0, 0, time.Local)
+		}
+
+		component := "VEVENT"
+		if u.Todo {
+			component = "VTODO"
+		}
+		w.line("BEGIN", component)
+		w.line("UID", fmt.Sprintf("%s-%s-%d@plant", sub.UID, s.Kind, n))
+		w.line("DTSTAMP", stamp)
+		w.text("SUMMARY", action+": "+sub.Name)
+		w.text("DESCRIPTION", fmt.Sprintf("每%d天%s\n%s", n, action, s.Text))
+		w.line("DTSTART;VALUE=DATE", icsDate(start))
+		if u.Todo {
+			w.line("DUE;VALUE=DATE", icsDate(start.AddDate(0, 0, 1)))
+		} else {
+			w.line("DTEND;VALUE=DATE", icsDate(start.AddDate(0, 0, 1)))
+			w.line("TRANSP", "TRANSPARENT")
+		}
+		w.line("RRULE", fmt.Sprintf("FREQ=DAILY;INTERVAL=%d%s", n, icsByMonth(months)))
+		w.line("CATEGORIES", s.Kind)
+		w.line("BEGIN", "VALARM")
+		w.line("ACTION", "DISPLAY")
+		w.text("DESCRIPTION", action+": "+sub.Name)
+		w.line("TRIGGER;RELATED=START", fmt.Sprintf("PT%dH", u.Hour))
+		w.line("END", "VALARM")
+		w.line("END", component)
+	}
+}
+
+// writeFlowering 为花期输出每年重复的全天事件, 跨年的花期 (如 12-2月) 按连续月份输出
+func (w *icsWriter) writeFlowering(sub calendarSubject, today time.Time, stamp string) {
+	months := floweringMonths(sub.Plant)
+	if len(months) == 0 {
+		return
+	}
+
+	// 连续月份合并为一段, 12 月与 1 月相连
+	var runs [][2]int
+	for _, m := range months {
+		if len(runs) > 0 && runs[len(runs)-1][1] == m-1 {
+			runs[len(runs)-1][1] = m
+			continue
+		}
+		runs = append(runs, [2]int{m, m})
+	}
+	if len(runs) > 1 && runs[0][0] == 1 && runs[len(runs)-1][1] == 12 {
+		runs[len(runs)-1][1] = runs[0][1] + 12
+		runs = runs[1:]
+	}
+
+	for _, run := range runs {
+		start := time.Date(today.Year(), time.Month(run[0]), 1, 0, 0, 0, 0, time.Local)
+		end := time.Date(today.Year(), time.Month(run[1])+1, 1, 0, 0, 0, 0, time.Local)
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", fmt.Sprintf("%s-%s-%d@plant", sub.UID, careFlowering, run[0]))
+		w.line("DTSTAMP", stamp)
+		w.text("SUMMARY", "花期: "+sub.Name)
+		w.text("DESCRIPTION", sub.Plant.Period)
+		w.line("DTSTART;VALUE=DATE", icsDate(start))
+		w.line("DTEND;VALUE=DATE", icsDate(end))
+		w.line("RRULE", "FREQ=YEARLY")
+		w.line("TRANSP", "TRANSPARENT")
+		w.line("CATEGORIES", careFlowering)
+		w.line("END", "VEVENT")
+	}
+}
+
+// calendarHandler 输出订阅者的 iCalendar 日历, 包含浇水施肥的重复提醒和每年的花期
+func calendarHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(r.PathValue("name"), ".ics")
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "calendar not found", nil)
+		return
+	}
+	idx := slices.IndexFunc(calendarUsers, func(u calendarUser) bool { return u.Name == name })
+	if idx < 0 {
+		writeError(w, http.StatusNotFound, "not_found", "calendar not found", nil)
+		return
+	}
+	u := calendarUsers[idx]
+	if u.Token != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(u.Token)) != 1 {
+		writeError(w, http.StatusForbidden, "forbidden", "invalid calendar token", nil)
+		return
+	}
+	if v := r.URL.Query().Get("todo"); v != "" {
+		u.Todo, _ = strconv.ParseBool(v)
+	}
+
+	subjects, err := calendarSubjects(&u)
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+
+	today := dateOf(time.Now())
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+
+	var ics icsWriter
+	ics.line("BEGIN", "VCALENDAR")
+	ics.line("VERSION", "2.0")
+	ics.line("PRODID", "-//xshrim//plant//ZH")
+	ics.line("CALSCALE", "GREGORIAN")
+	ics.line("METHOD", "PUBLISH")
+	ics.text("X-WR-CALNAME", "植物养护 "+u.Name)
+	ics.line("REFRESH-INTERVAL;VALUE=DURATION", "PT6H")
+	ics.line("X-PUBLISHED-TTL", "PT6H")
+	for _, sub := range subjects {
+		for _, s := range plantSchedules(sub.Plant) {
+			if u.wants(s.Kind) {
+				ics.writeCare(&u, sub, s, today, stamp)
+			}
+		}
+		if u.wants(careFlowering) {
+			ics.writeFlowering(sub, today, stamp)
+		}
+	}
+	ics.line("END", "VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.ics"`, u.Name))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(ics.b.String()))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestICSLineFolding(t *testing.T) {
+	tests := []struct {
+		name, value string
+	}{
+		{"short", "浇水"},
+		{"ascii", strings.Repeat("a", 200)},
+		{"exactly 75", strings.Repeat("b", 75-len("SUMMARY:"))},
+		{"chinese", strings.Repeat("给龟背竹浇水", 20)},
+		{"mixed", "Monstera 龟背竹 " + strings.Repeat("见干见湿, 冬季控水; ", 10)},
+	}
+	for _, tt := range tests {
+		var w icsWriter
+		w.line("SUMMARY", tt.value)
+		out := w.b.String()
+
+		if !strings.HasSuffix(out, "\r\n") {
+			t.Errorf("%s: output does not end with CRLF", tt.name)
+		}
+		lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+		for i, l := range lines {
+			if len(l) > 75 {
+				t.Errorf("%s: line %d is %d bytes", tt.name, i, len(l))
+			}
+			if !utf8.ValidString(l) {
+				t.Errorf("%s: line %d splits a character", tt.name, i)
+			}
+			if i > 0 && !strings.HasPrefix(l, " ") {
+				t.Errorf("%s: continuation line %d does not start with a space", tt.name, i)
+			}
+		}
+		// 展开续行后应与原文一致
+		if got := strings.ReplaceAll(strings.TrimSuffix(out, "\r\n"), "\r\n ", ""); got != "SUMMARY:"+tt.value {
+			t.Errorf("%s: unfolded %q, want %q", tt.name, got, "SUMMARY:"+tt.value)
+		}
+	}
+}
+
+func TestICSText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"浇水", "DESCRIPTION:浇水\r\n"},
+		{"见干见湿, 冬季控水; 偶尔喷雾", `DESCRIPTION:见干见湿\, 冬季控水\; 偶尔喷雾` + "\r\n"},
+		{"第一行\n第二行\r\n第三行", `DESCRIPTION:第一行\n第二行\n第三行` + "\r\n"},
+		{`C:\plants`, `DESCRIPTION:C:\\plants` + "\r\n"},
+	}
+	for _, tt := range tests {
+		var w icsWriter
+		w.text("DESCRIPTION", tt.in)
+		if got := w.b.String(); got != tt.want {
+			t.Errorf("text(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestICSByMonth(t *testing.T) {
+	tests := []struct {
+		months []int
+		want   string
+	}{
+		{nil, ""},
+		{[]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}, ""},
+		{[]int{4, 5, 6}, ";BYMONTH=4,5,6"},
+		{[]int{11, 12, 1, 2}, ";BYMONTH=11,12,1,2"},
+	}
+	for _, tt := range tests {
+		if got := icsByMonth(tt.months); got != tt.want {
+			t.Errorf("icsByMonth(%v) = %q, want %q", tt.months, got, tt.want)
+		}
+	}
+}
+
+func TestCalendarSubjectsSkipsMissing(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openStore("json", filepath.Join(dir, "plants.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := openJSONCollection(filepath.Join(dir, "collection.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(old PlantStore, oldc CollectionStore) { store, collection = old, oldc }(store, collection)
+	store, collection = s, c
+
+	lily, pothos := &Plant{Cnname: "铃兰", Enname: "Lily of the Valley"}, &Plant{Cnname: "绿萝", Enname: "Pothos"}
+	for _, p := range []*Plant{lily, pothos} {
+		if err := store.Put(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, sp := range []*Specimen{{ID: "a", PlantID: lily.ID, Nickname: "小铃兰"}, {ID: "b", PlantID: pothos.ID, Nickname: "小绿萝"}} {
+		if err := collection.Put(sp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 删除后收藏中仍引用绿萝
+	if err := store.Delete(pothos.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		user calendarUser
+		want []string
+	}{
+		{"catalog", calendarUser{}, []string{"铃兰"}},
+		{"missing plant skipped", calendarUser{Plants: []string{"铃兰", "绿萝"}}, []string{"铃兰"}},
+		{"all plants missing", calendarUser{Plants: []string{"绿萝"}}, nil},
+		{"collection", calendarUser{Collection: true}, []string{"小铃兰"}},
+	}
+	for _, tt := range tests {
+		subjects, err := calendarSubjects(&tt.user)
+		if err != nil {
+			t.Errorf("%s: err = %v", tt.name, err)
+			continue
+		}
+		var names []string
+		for _, s := range subjects {
+			names = append(names, s.Name)
+		}
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("%s: subjects = %v, want %v", tt.name, names, tt.want)
+		}
+	}
+}
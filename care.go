@@ -0,0 +1,602 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	careWatering      = "watering"
+	careFertilization = "fertilization"
+)
+
+var careKinds = []string{careWatering, careFertilization}
+
+// defaultCareInterval 无法从描述中解析出间隔时使用的默认天数
+var defaultCareInterval = map[string]int{careWatering: 7, careFertilization: 30}
+
+// careRule 一段时间内的养护间隔
+type careRule struct {
+	Months   []int `json:"months,omitempty"` // 适用的月份, 为空表示全年
+	Interval int   `json:"interval"`         // 间隔天数, 0 表示暂停
+}
+
+// careSchedule 从浇水或施肥描述中解析出的养护计划, 指定月份的规则优先于全年规则, 同类规则中后面的优先
+type careSchedule struct {
+	Kind   string     `json:"kind"`
+	Text   string     `json:"text"`
+	Rules  []careRule `json:"rules"`
+	Parsed bool       `json:"parsed"` // 为 false 时描述中没有可识别的间隔, 使用默认间隔
+}
+
+// careSeasons 季节关键字对应的月份
+var careSeasons = []struct {
+	keys   []string
+	months []int
+}{
+	{[]string{"生长期", "生长季"}, []int{3, 4, 5, 6, 7, 8, 9, 10}},
+	{[]string{"休眠期"}, []int{11, 12, 1, 2}},
+	{[]string{"春"}, []int{3, 4, 5}},
+	{[]string{"夏"}, []int{6, 7, 8}},
+	{[]string{"秋"}, []int{9, 10, 11}},
+	{[]string{"冬"}, []int{12, 1, 2}},
+}
+
+var careUnits = map[string]int{"天": 1, "日": 1, "周": 7, "星期": 7, "月": 30, "个月": 30, "年": 365}
+
+var (
+	careClauseSep    = regexp.MustCompile(`[，,。；;\n]+`)
+	careEveryN       = regexp.MustCompile(`每(?:隔)?(\d+)(?:\s*[-~～至到]\s*(\d+))?\s*(天|日|周|星期|个月|月|年)`)
+	careTimesPer     = regexp.MustCompile(`(?:每|1)(天|日|周|星期|个月|月|年)[^\d]{0,4}?(\d+)(?:\s*[-~～至到]\s*(\d+))?\s*次`)
+	careNOnce        = regexp.MustCompile(`(\d+)(?:\s*[-~～至到]\s*(\d+))?\s*(天|日|周|星期|个月|月|年)[^\d]{0,4}?1次`)
+	careHalfMonth    = regexp.MustCompile(`半个?月`)
+	careEvery        = regexp.MustCompile(`每(天|日|周|星期|个月|月|年)`)
+	careMonthRange   = regexp.MustCompile(`(\d{1,2})月?(?:\s*[-~～至到]\s*(\d{1,2}))?\s*月`)
+	careRestWords    = []string{"其余", "其他", "其它", "平时", "全年"}
+	careStopWords    = []string{"停止", "暂停", "停肥", "不施", "无需", "不需", "不用"}
+	careMoreWords    = []string{"增加", "多浇", "勤浇", "充足"}
+	careLessWords    = []string{"减少", "控制", "少浇", "控水", "节制"}
+	careMoistWords   = []string{"湿润", "勤浇"}
+	careDryWords     = []string{"见干见湿", "干透浇透", "表土干", "盆土干", "土干"}
+	careDroughtWords = []string{"耐旱", "干燥"}
+)
+
+// chineseDigits 中文数字 1-9
+var chineseDigits = map[rune]int{'一': 1, '二': 2, '两': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+
+// replaceNumerals 将中文数字替换为阿拉伯数字: 十五 → 15, 二十 → 20, 二十一 → 21;
+// 相邻的数字表示约数范围: 三四 → 3-4, 一两 → 1-2
+func replaceNumerals(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && (runes[j] == '十' || chineseDigits[runes[j]] > 0) {
+			j++
+		}
+		if j == i {
+			b.WriteRune(runes[i])
+			i++
+			continue
+		}
+		b.WriteString(numeral(runes[i:j]))
+		i = j
+	}
+
+	return b.String()
+}
+
+// numeral 转换一段连续的中文数字: X十Y 为 10X+Y, 十Y 为 10+Y, X十 为 10X; 不含十的多个数字为首尾两个数字的范围
+func numeral(rs []rune) string {
+	if !slices.Contains(rs, '十') {
+		from := strconv.Itoa(chineseDigits[rs[0]])
+		if len(rs) == 1 {
+			return from
+		}
+		return from + "-" + strconv.Itoa(chineseDigits[rs[len(rs)-1]])
+	}
+
+	total, cur := 0, 0
+	for _, r := range rs {
+		if r == '十' {
+			total += max(cur, 1) * 10
+			cur = 0
+			continue
+		}
+		cur = chineseDigits[r]
+	}
+
+	return strconv.Itoa(total + cur)
+}
+
+func containsAny(s string, words []string) bool {
+	return slices.ContainsFunc(words, func(w string) bool { return strings.Contains(s, w) })
+}
+
+// parseCare 将 "生长期每2-3周施一次稀释的液态肥, 冬季停止施肥" 形式的描述按分句解析为分季节的间隔
+func parseCare(kind, text string) careSchedule {
+	s := careSchedule{Kind: kind, Text: text}
+	def := defaultCareInterval[kind]
+
+	var prev []int
+	for _, clause := range careClauseSep.Split(text, -1) {
+		clause = replaceNumerals(strings.TrimSpace(clause))
+		if clause == "" {
+			continue
+		}
+
+		interval, rest, ok := parseCareInterval(kind, clause, func(months []int) int {
+			if len(months) == 0 {
+				return s.intervalIn(0, def)
+			}
+			return s.intervalIn(time.Month(months[0]), def)
+		})
+		months := parseCareMonths(rest)
+		// "冬季控水, 每半月一次" 中没有季节的分句沿用上一分句的季节, "其余时间" 等除外
+		if months == nil && !containsAny(clause, careRestWords) {
+			months = prev
+		}
+		prev = months
+		if !ok {
+			continue
+		}
+		s.Rules = append(s.Rules, careRule{Months: months, Interval: interval})
+	}
+
+	if len(s.Rules) == 0 {
+		s.Rules = []careRule{{Interval: def}}
+		return s
+	}
+	s.Parsed = true
+
+	// 只描述了部分季节时补充其余月份: 施肥暂停, 浇水间隔加倍
+	if !slices.ContainsFunc(s.Rules, func(r careRule) bool { return len(r.Months) == 0 }) {
+		base := careRule{}
+		if kind == careWatering {
+			base.Interval = min(s.Rules[0].Interval*2, 30)
+			if base.Interval == 0 {
+				base.Interval = def
+			}
+		}
+		s.Rules = append([]careRule{base}, s.Rules...)
+	}
+
+	return s
+}
+
+// parseCareInterval 解析分句中的间隔天数, 返回去掉间隔后的剩余文本用于识别月份;
+// "增加浇水" 等相对描述以分句所在月份已有的间隔 (current) 为基准
+func parseCareInterval(kind, clause string, current func(months []int) int) (int, string, bool) {
+	if containsAny(clause, careStopWords) {
+		return 0, clause, true
+	}
+
+	if m := careEveryN.FindStringSubmatch(clause); m != nil {
+		return roundDays(avgRange(m[1], m[2]) * float64(careUnits[m[3]])), strings.Replace(clause, m[0], "", 1), true
+	}
+	if m := careTimesPer.FindStringSubmatch(clause); m != nil {
+		return roundDays(float64(careUnits[m[1]]) / avgRange(m[2], m[3])), strings.Replace(clause, m[0], "", 1), true
+	}
+	// "2-3天浇1次"
+	if m := careNOnce.FindStringSubmatch(clause); m != nil {
+		return roundDays(avgRange(m[1], m[2]) * float64(careUnits[m[3]])), strings.Replace(clause, m[0], "", 1), true
+	}
+	if m := careHalfMonth.FindString(clause); m != "" {
+		return 15, strings.Replace(clause, m, "", 1), true
+	}
+	if m := careEvery.FindStringSubmatch(clause); m != nil {
+		return careUnits[m[1]], strings.Replace(clause, m[0], "", 1), true
+	}
+
+	if kind == careFertilization {
+		if strings.Contains(clause, "薄肥勤施") {
+			return 14, clause, true
+		}
+		return 0, clause, false
+	}
+
+	switch {
+	case containsAny(clause, careMoreWords):
+		return max(roundDays(float64(current(parseCareMonths(clause)))*0.5), 1), clause, true
+	case containsAny(clause, careLessWords):
+		return roundDays(float64(current(parseCareMonths(clause))) * 2), clause, true
+	case containsAny(clause, careMoistWords):
+		return 3, clause, true
+	case containsAny(clause, careDryWords):
+		return 7, clause, true
+	case containsAny(clause, careDroughtWords):
+		return 14, clause, true
+	}
+
+	return 0, clause, false
+}
+
+func avgRange(lo, hi string) float64 {
+	a, _ := strconv.Atoi(lo)
+	if b, err := strconv.Atoi(hi); err == nil && b > 0 {
+		return float64(a+b) / 2
+	}
+
+	return float64(max(a, 1))
+}
+
+func roundDays(d float64) int {
+	return max(int(math.Round(d)), 1)
+}
+
+// parseCareMonths 识别分句中的季节关键字和 "5-6月" 形式的月份, 没有时返回 nil 表示全年
+func parseCareMonths(clause string) []int {
+	var months []int
+	for _, season := range careSeasons {
+		if containsAny(clause, season.keys) {
+			months = append(months, season.months...)
+		}
+	}
+	for _, m := range careMonthRange.FindAllStringSubmatch(clause, -1) {
+		from, _ := strconv.Atoi(m[1])
+		to := from
+		if m[2] != "" {
+			to, _ = strconv.Atoi(m[2])
+		}
+		if from < 1 || from > 12 || to < 1 || to > 12 {
+			continue
+		}
+		for mon := from; ; mon = mon%12 + 1 {
+			months = append(months, mon)
+			if mon == to {
+				break
+			}
+		}
+	}
+
+	slices.Sort(months)
+	return slices.Compact(months)
+}
+
+// intervalIn 返回 month 月的间隔天数, month 为 0 时返回全年规则的间隔; 没有匹配的规则时返回 def
+func (s *careSchedule) intervalIn(month time.Month, def int) int {
+	for i := len(s.Rules) - 1; i >= 0; i-- {
+		if slices.Contains(s.Rules[i].Months, int(month)) {
+			return s.Rules[i].Interval
+		}
+	}
+	for i := len(s.Rules) - 1; i >= 0; i-- {
+		if len(s.Rules[i].Months) == 0 {
+			return s.Rules[i].Interval
+		}
+	}
+
+	return def
+}
+
+// IntervalIn 返回 month 月的间隔天数, 0 表示该月暂停
+func (s *careSchedule) IntervalIn(month time.Month) int {
+	return s.intervalIn(month, defaultCareInterval[s.Kind])
+}
+
+// nextActive 返回 from 之后第一个未暂停月份的第一天
+func (s *careSchedule) nextActive(from time.Time) (time.Time, bool) {
+	d := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, from.Location())
+	for i := 0; i < 12; i++ {
+		d = d.AddDate(0, 1, 0)
+		if s.IntervalIn(d.Month()) > 0 {
+			return d, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// NextDue 根据上次完成的日期计算下次到期日, 从未完成时为今天; 到期日落在暂停的月份时顺延到下一个未暂停月份的第一天,
+// 全年暂停时返回 false
+func (s *careSchedule) NextDue(last *time.Time, today time.Time) (time.Time, bool) {
+	if last == nil {
+		if s.IntervalIn(today.Month()) > 0 {
+			return today, true
+		}
+		return s.nextActive(today)
+	}
+
+	d := dateOf(*last)
+	interval := s.IntervalIn(d.Month())
+	if interval == 0 {
+		return s.nextActive(d)
+	}
+	due := d.AddDate(0, 0, interval)
+	if s.IntervalIn(due.Month()) == 0 {
+		return s.nextActive(due)
+	}
+
+	return due, true
+}
+
+// dateOf 返回本地时区当天零点
+func dateOf(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// plantSchedules 返回植物的浇水和施肥计划
+func plantSchedules(p *Plant) []careSchedule {
+	return []careSchedule{parseCare(careWatering, p.Watering), parseCare(careFertilization, p.Fertilization)}
+}
+
+// careLog 记录每株植物每种养护最近一次完成的时间, 持久化到 json 文件
+type careLog struct {
+	mu   sync.Mutex
+	path string
+	done map[string]map[string]time.Time // 植物 id -> 养护类型 -> 完成时间
+}
+
+var cares *careLog
+
+func openCareLog(path string) *careLog {
+	l := &careLog{path: path, done: map[string]map[string]time.Time{}}
+	if path == "" {
+		return l
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("failed to read care log:", err)
+		}
+		return l
+	}
+	if err := json.Unmarshal(data, &l.done); err != nil {
+		log.Println("failed to unmarshal care log:", err)
+		l.done = map[string]map[string]time.Time{}
+	}
+
+	return l
+}
+
+// Last 返回植物某种养护最近一次完成的时间
+func (l *careLog) Last(id, kind string) *time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t, ok := l.done[id][kind]
+	if !ok {
+		return nil
+	}
+
+	return &t
+}
+
+// Done 记录一次养护, 早于已记录时间的补记不会覆盖最近一次完成时间
+func (l *careLog) Done(id, kind string, at time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.done[id] == nil {
+		l.done[id] = map[string]time.Time{}
+	}
+	if last, ok := l.done[id][kind]; ok && last.After(at) {
+		return nil
+	}
+	l.done[id][kind] = at
+
+	return l.save()
+}
+
+// Reset 将最近一次完成时间改为 at, at 为 nil 时清除记录
+func (l *careLog) Reset(id, kind string, at *time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if at == nil {
+		delete(l.done[id], kind)
+	} else {
+		if l.done[id] == nil {
+			l.done[id] = map[string]time.Time{}
+		}
+		l.done[id][kind] = *at
+	}
+
+	return l.save()
+}
+
+// Forget 删除植物的全部养护记录, 用于删除植物时
+func (l *careLog) Forget(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.done[id]; !ok {
+		return nil
+	}
+	delete(l.done, id)
+
+	return l.save()
+}
+
+// Snapshot 返回全部记录的副本
+func (l *careLog) Snapshot() map[string]map[string]time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	res := make(map[string]map[string]time.Time, len(l.done))
+	for id, kinds := range l.done {
+		res[id] = maps.Clone(kinds)
+	}
+
+	return res
+}
+
+// save 持久化记录, 调用方需持有锁
+func (l *careLog) save() error {
+	if l.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(l.done, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(l.path, data, nil)
+}
+
+// careTask 一株植物的下一次浇水或施肥
+type careTask struct {
+	PlantID  string     `json:"plant_id"`
+	Cnname   string     `json:"cnname"`
+	Image    string     `json:"image"`
+	Kind     string     `json:"kind"`
+	Text     string     `json:"text"`
+	Interval int        `json:"interval"` // 到期日所在月份的间隔天数
+	LastDone *time.Time `json:"last_done,omitempty"`
+	Due      string     `json:"due"`     // 到期日期 YYYY-MM-DD
+	Overdue  int        `json:"overdue"` // 逾期天数
+}
+
+// plantTasks 计算植物每种养护的下一次任务, 全年暂停的养护不产生任务
+func plantTasks(p *Plant, today time.Time) []careTask {
+	var tasks []careTask
+	for _, s := range plantSchedules(p) {
+		last := cares.Last(p.ID, s.Kind)
+		due, ok := s.NextDue(last, today)
+		if !ok {
+			continue
+		}
+		tasks = append(tasks, careTask{
+			PlantID:  p.ID,
+			Cnname:   p.Cnname,
+			Image:    p.Image,
+			Kind:     s.Kind,
+			Text:     s.Text,
+			Interval: s.IntervalIn(due.Month()),
+			LastDone: last,
+			Due:      due.Format(time.DateOnly),
+			Overdue:  max(int(today.Sub(due).Hours()/24), 0),
+		})
+	}
+
+	return tasks
+}
+
+// careTasksHandler 返回 date (默认今天) 起 days 天内到期和已逾期的养护任务, 逾期最久的在前
+func careTasksHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	today := dateOf(time.Now())
+	if v := q.Get("date"); v != "" {
+		d, err := time.ParseInLocation(time.DateOnly, v, time.Local)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("invalid date: %s", v), nil)
+			return
+		}
+		today = d
+	}
+	days := 0
+	if v := q.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("invalid days: %s", v), nil)
+			return
+		}
+		days = n
+	}
+	until := today.AddDate(0, 0, days).Format(time.DateOnly)
+
+	plants, err := store.List()
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	tasks := []careTask{}
+	for _, p := range plants {
+		for _, t := range plantTasks(p, today) {
+			if t.Due <= until {
+				tasks = append(tasks, t)
+			}
+		}
+	}
+	slices.SortStableFunc(tasks, func(a, b careTask) int {
+		if c := strings.Compare(a.Due, b.Due); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Cnname, b.Cnname)
+	})
+
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// plantCareHandler 返回植物解析后的养护计划和下一次任务
+func plantCareHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := store.Get(r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"plant_id":  p.ID,
+		"schedules": plantSchedules(p),
+		"tasks":     plantTasks(p, dateOf(time.Now())),
+	})
+}
+
+// careDoneHandler 记录完成一次浇水或施肥并写入养护日志, 请求体可选 {"at": "2006-01-02T15:04:05Z07:00", "specimen_id": "", "notes": ""}, 返回更新后的任务
+func careDoneHandler(w http.ResponseWriter, r *http.Request) {
+	kind := r.PathValue("kind")
+	if !slices.Contains(careKinds, kind) {
+		writeError(w, http.StatusUnprocessableEntity, "validation_failed", fmt.Sprintf("unknown care kind: %s", kind), map[string]string{"kind": "must be one of " + strings.Join(careKinds, ", ")})
+		return
+	}
+
+	p, err := store.Get(r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var body struct {
+		At         *time.Time `json:"at"`
+		SpecimenID string     `json:"specimen_id"`
+		Notes      string     `json:"notes"`
+	}
+	data, err := readBody(r)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if len(strings.TrimSpace(string(data))) > 0 {
+		if err := json.Unmarshal(data, &body); err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("unmarshalling json error: %v", err), nil)
+			return
+		}
+	}
+	e := CareEvent{PlantID: p.ID, SpecimenID: body.SpecimenID, Kind: kind, Notes: body.Notes}
+	if body.At != nil {
+		e.At = *body.At
+	}
+	if err := prepareEvent(&e); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if err := recordCare(&e); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", fmt.Sprintf("failed to save care log: %v", err), nil)
+		return
+	}
+
+	for _, t := range plantTasks(p, dateOf(time.Now())) {
+		if t.Kind == kind {
+			writeJSON(w, http.StatusOK, t)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, nil)
+}
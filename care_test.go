@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplaceNumerals(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"每十天", "每10天"},
+		{"每十五天", "每15天"},
+		{"每二十天", "每20天"},
+		{"每二十一天", "每21天"},
+		{"每三十天", "每30天"},
+		{"十一月至次年二月", "11月至次年2月"},
+		{"十二月", "12月"},
+		{"每两周一次", "每2周1次"},
+		{"每隔三四天", "每隔3-4天"},
+		{"两三天浇一次水", "2-3天浇1次水"},
+		{"一两周", "1-2周"},
+		{"零下五度", "零下5度"},
+		{"见干见湿", "见干见湿"},
+	}
+	for _, tt := range tests {
+		if got := replaceNumerals(tt.in); got != tt.want {
+			t.Errorf("replaceNumerals(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseCare(t *testing.T) {
+	tests := []struct {
+		kind, text string
+		month      time.Month
+		want       int
+	}{
+		{careWatering, "每十五天浇水一次", time.May, 15},
+		{careWatering, "每二十天浇一次水", time.May, 20},
+		{careWatering, "每二十一天浇水", time.May, 21},
+		{careFertilization, "每三十天施一次肥", time.May, 30},
+		{careWatering, "每周浇水2次", time.May, 4},
+		{careWatering, "半个月浇一次", time.May, 15},
+		{careFertilization, "生长期每2-3周施一次稀释的液态肥, 冬季停止施肥", time.May, 18},
+		{careFertilization, "生长期每2-3周施一次稀释的液态肥, 冬季停止施肥", time.January, 0},
+		{careWatering, "夏季每天浇水, 冬季控水", time.July, 1},
+		{careWatering, "夏季每天浇水, 冬季控水", time.December, 14},
+		{careWatering, "每隔三四天浇水", time.May, 4},
+		{careWatering, "两三天浇一次水", time.May, 3},
+		{careFertilization, "一两周施一次肥", time.May, 11},
+		{careFertilization, "每一两周施一次肥", time.May, 11},
+		{careWatering, "随意", time.May, 7},
+	}
+	for _, tt := range tests {
+		s := parseCare(tt.kind, tt.text)
+		if got := s.IntervalIn(tt.month); got != tt.want {
+			t.Errorf("parseCare(%q, %q).IntervalIn(%d) = %d, want %d", tt.kind, tt.text, tt.month, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errSpecimenNotFound = errors.New("specimen not found")
+	errPlantInUse       = errors.New("plant is referenced by the collection")
+)
+
+// Specimen 实际拥有的一株植物, PlantID 引用目录中的植物, 同一种植物可以有多株
+type Specimen struct {
+	ID         string    `json:"id"`
+	PlantID    string    `json:"plant_id"`
+	Nickname   string    `json:"nickname"`
+	Location   string    `json:"location"`    // 摆放位置, 如阳台, 办公室
+	AcquiredAt string    `json:"acquired_at"` // 入手日期 YYYY-MM-DD
+	PotSize    string    `json:"pot_size"`    // 花盆尺寸, 如 12cm
+	Notes      string    `json:"notes"`
+	Cover      string    `json:"cover,omitempty"` // 封面照片
+	Photos     []Photo   `json:"photos,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// CollectionStore 收藏的存储, 与植物目录使用相同的后端:
+// json 目录的收藏保存在单独的 json 文件中, sqlite 目录的收藏保存在同一数据库的 specimens 表中
+type CollectionStore interface {
+	Get(id string) (*Specimen, error)
+	List() ([]*Specimen, error)
+	Put(s *Specimen) error
+	Update(id string, s *Specimen) error
+	// Modify 在存储的锁 (或事务) 内读取收藏并由 fn 原地修改后写回, fn 返回错误时不写入
+	Modify(id string, fn func(s *Specimen) error) (*Specimen, error)
+	Delete(id string) error
+}
+
+var collection CollectionStore
+
+// openCollection 打开与植物目录同一后端的收藏存储, path 仅用于 json 后端
+func openCollection(catalog PlantStore, path string) (CollectionStore, error) {
+	if s, ok := catalog.(*sqliteStore); ok {
+		return openSQLiteCollection(s.db)
+	}
+	if path == "" {
+		path = "collection.json"
+	}
+
+	return openJSONCollection(path)
+}
+
+func cloneSpecimen(s *Specimen) *Specimen {
+	c := *s
+	c.Photos = slices.Clone(s.Photos)
+	return &c
+}
+
+// jsonCollection 将收藏保存在一个 json 文件中, 每次修改整体重写
+type jsonCollection struct {
+	mu        sync.RWMutex
+	path      string
+	specimens []*Specimen
+}
+
+func openJSONCollection(path string) (*jsonCollection, error) {
+	c := &jsonCollection{path: path, specimens: []*Specimen{}}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, fmt.Errorf("failed to read collection: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.specimens); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal collection: %w", err)
+	}
+
+	return c, nil
+}
+
+func (c *jsonCollection) index(id string) int {
+	return slices.IndexFunc(c.specimens, func(s *Specimen) bool { return s.ID == id })
+}
+
+func (c *jsonCollection) Get(id string) (*Specimen, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	idx := c.index(id)
+	if idx < 0 {
+		return nil, errSpecimenNotFound
+	}
+
+	return cloneSpecimen(c.specimens[idx]), nil
+}
+
+func (c *jsonCollection) List() ([]*Specimen, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	res := make([]*Specimen, 0, len(c.specimens))
+	for _, s := range c.specimens {
+		res = append(res, cloneSpecimen(s))
+	}
+
+	return res, nil
+}
+
+func (c *jsonCollection) Put(s *Specimen) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.flush(append(slices.Clone(c.specimens), cloneSpecimen(s)))
+}
+
+func (c *jsonCollection) Update(id string, s *Specimen) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	idx := c.index(id)
+	if idx < 0 {
+		return errSpecimenNotFound
+	}
+	specimens := slices.Clone(c.specimens)
+	specimens[idx] = cloneSpecimen(s)
+
+	return c.flush(specimens)
+}
+
+func (c *jsonCollection) Modify(id string, fn func(s *Specimen) error) (*Specimen, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	idx := c.index(id)
+	if idx < 0 {
+		return nil, errSpecimenNotFound
+	}
+	s := cloneSpecimen(c.specimens[idx])
+	if err := fn(s); err != nil {
+		return nil, err
+	}
+	s.ID = c.specimens[idx].ID
+
+	specimens := slices.Clone(c.specimens)
+	specimens[idx] = s
+	if err := c.flush(specimens); err != nil {
+		return nil, err
+	}
+
+	return cloneSpecimen(s), nil
+}
+
+func (c *jsonCollection) Delete(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	idx := c.index(id)
+	if idx < 0 {
+		return errSpecimenNotFound
+	}
+
+	return c.flush(slices.Delete(slices.Clone(c.specimens), idx, idx+1))
+}
+
+// flush 写入文件成功后才替换内存中的数据, 调用方需持有锁
+func (c *jsonCollection) flush(specimens []*Specimen) error {
+	data, err := json.MarshalIndent(specimens, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.path, data, nil); err != nil {
+		return err
+	}
+	c.specimens = specimens
+
+	return nil
+}
+
+// sqliteCollection 与植物目录共用数据库连接, 连接由目录负责关闭
+type sqliteCollection struct {
+	db *sql.DB
+}
+
+func openSQLiteCollection(db *sql.DB) (*sqliteCollection, error) {
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS specimens (
+	id       TEXT NOT NULL PRIMARY KEY,
+	plant_id TEXT NOT NULL,
+	data     TEXT NOT NULL
+)`,
+		`CREATE INDEX IF NOT EXISTS idx_specimens_plant_id ON specimens (plant_id)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("failed to migrate collection: %w", err)
+		}
+	}
+
+	return &sqliteCollection{db: db}, nil
+}
+
+func (c *sqliteCollection) Get(id string) (*Specimen, error) {
+	var data string
+	err := c.db.QueryRow(`SELECT data FROM specimens WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errSpecimenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var s Specimen
+	if err := json.Unmarshal([]byte(data), &s); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal json: %w", err)
+	}
+
+	return &s, nil
+}
+
+func (c *sqliteCollection) List() ([]*Specimen, error) {
+	rows, err := c.db.Query(`SELECT data FROM specimens ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*Specimen{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var s Specimen
+		if err := json.Unmarshal([]byte(data), &s); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal json: %w", err)
+		}
+		res = append(res, &s)
+	}
+
+	return res, rows.Err()
+}
+
+func (c *sqliteCollection) Put(s *Specimen) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.db.Exec(`INSERT INTO specimens (id, plant_id, data) VALUES (?, ?, ?)`, s.ID, s.PlantID, string(data))
+	return err
+}
+
+func (c *sqliteCollection) Update(id string, s *Specimen) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.db.Exec(`UPDATE specimens SET plant_id = ?, data = ? WHERE id = ?`, s.PlantID, string(data), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errSpecimenNotFound
+	}
+
+	return nil
+}
+
+func (c *sqliteCollection) Modify(id string, fn func(s *Specimen) error) (*Specimen, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var data string
+	err = tx.QueryRow(`SELECT data FROM specimens WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errSpecimenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s Specimen
+	if err := json.Unmarshal([]byte(data), &s); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal json: %w", err)
+	}
+
+	if err := fn(&s); err != nil {
+		return nil, err
+	}
+	s.ID = id
+
+	updated, err := json.Marshal(&s)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE specimens SET plant_id = ?, data = ? WHERE id = ?`, s.PlantID, string(updated), id); err != nil {
+		return nil, err
+	}
+
+	return &s, tx.Commit()
+}
+
+func (c *sqliteCollection) Delete(id string) error {
+	res, err := c.db.Exec(`DELETE FROM specimens WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errSpecimenNotFound
+	}
+
+	return nil
+}
+
+// plantInUse 目录中的植物是否被收藏引用, 被引用的植物不能删除
+func plantInUse(plantID string) (bool, error) {
+	specimens, err := collection.List()
+	if err != nil {
+		return false, err
+	}
+
+	return slices.ContainsFunc(specimens, func(s *Specimen) bool { return s.PlantID == plantID }), nil
+}
+
+// specimenView 返回给客户端的收藏, 附带引用的目录植物
+type specimenView struct {
+	*Specimen
+	Plant *Plant `json:"plant,omitempty"`
+}
+
+func viewSpecimen(s *Specimen) specimenView {
+	p, err := store.Get(s.PlantID)
+	if err != nil {
+		log.Printf("failed to get plant %s of specimen %s: %v\n", s.PlantID, s.ID, err)
+	}
+
+	return specimenView{Specimen: s, Plant: p}
+}
+
+// prepareSpecimen 校验收藏并通过 lookup 将 plant_id 解析为目录植物的 id, 未填写昵称时使用植物中文名
+func prepareSpecimen(s *Specimen, lookup func(key string) (*Plant, error)) error {
+	fields := map[string]string{}
+
+	s.Nickname = strings.TrimSpace(s.Nickname)
+	s.Location = strings.TrimSpace(s.Location)
+	s.AcquiredAt = strings.TrimSpace(s.AcquiredAt)
+
+	if strings.TrimSpace(s.PlantID) == "" {
+		fields["plant_id"] = "is required"
+	} else if p, err := lookup(s.PlantID); err != nil {
+		fields["plant_id"] = err.Error()
+	} else {
+		s.PlantID = p.ID
+		if s.Nickname == "" {
+			s.Nickname = p.Cnname
+		}
+	}
+	if s.AcquiredAt != "" {
+		if _, err := time.Parse(time.DateOnly, s.AcquiredAt); err != nil {
+			fields["acquired_at"] = "must be a date like 2006-01-02"
+		}
+	}
+
+	if len(fields) > 0 {
+		return &validationError{Fields: fields}
+	}
+
+	return nil
+}
+
+// writeCollectionError 收藏不存在时返回 404, 其余错误同植物目录
+func writeCollectionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errSpecimenNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", err.Error(), nil)
+		return
+	}
+
+	writeStoreError(w, err)
+}
+
+// listCollectionHandler 列出收藏, 支持按 location 和 plant (id, slug 或名称) 过滤
+func listCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	specimens, err := collection.List()
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	plantID := ""
+	if key := q.Get("plant"); key != "" {
+		p, err := store.Get(key)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		plantID = p.ID
+	}
+	location := strings.TrimSpace(q.Get("location"))
+
+	views := []specimenView{}
+	for _, s := range specimens {
+		if (plantID != "" && s.PlantID != plantID) || (location != "" && s.Location != location) {
+			continue
+		}
+		views = append(views, viewSpecimen(s))
+	}
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+func getSpecimenHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := collection.Get(r.PathValue("id"))
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, viewSpecimen(s))
+}
+
+func createSpecimenHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var s Specimen
+	if err := json.Unmarshal(body, &s); err != nil {
+		writeStoreError(w, &badRequestError{fmt.Errorf("unmarshalling json error: %w", err)})
+		return
+	}
+	if err := prepareSpecimen(&s, store.Get); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	s.ID = newID()
+	s.CreatedAt = time.Now()
+	s.UpdatedAt = s.CreatedAt
+	// 照片只能通过上传接口添加
+	s.Photos, s.Cover = nil, ""
+
+	if err := collection.Put(&s); err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, viewSpecimen(&s))
+}
+
+// updateSpecimenHandler PUT 整体替换, PATCH 按 JSON merge patch 合并; 照片只能通过上传接口添加, 封面只能选择已有照片
+func updateSpecimenHandler(w http.ResponseWriter, r *http.Request) {
+	old, err := collection.Get(r.PathValue("id"))
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	var submitted struct {
+		PlantID *string         `json:"plant_id"`
+		Photos  json.RawMessage `json:"photos"`
+	}
+	if err := json.Unmarshal(body, &submitted); err != nil {
+		writeStoreError(w, &badRequestError{fmt.Errorf("unmarshalling json error: %w", err)})
+		return
+	}
+
+	// 在锁外查好可能引用的目录植物, sqlite 后端的收藏事务内不能再访问目录
+	plants := map[string]*Plant{}
+	for _, key := range []*string{&old.PlantID, submitted.PlantID} {
+		if key == nil || *key == "" {
+			continue
+		}
+		if p, err := store.Get(*key); err == nil {
+			plants[*key] = p
+		}
+	}
+	lookup := func(key string) (*Plant, error) {
+		if p, ok := plants[key]; ok {
+			return p, nil
+		}
+		return nil, errPlantNotFound
+	}
+
+	updated, err := collection.Modify(old.ID, func(cur *Specimen) error {
+		var s Specimen
+		if r.Method == http.MethodPut {
+			if err := json.Unmarshal(body, &s); err != nil {
+				return &badRequestError{fmt.Errorf("unmarshalling json error: %w", err)}
+			}
+		} else {
+			orig, err := json.Marshal(cur)
+			if err != nil {
+				return err
+			}
+			merged, err := mergePatch(orig, body)
+			if err != nil {
+				return &badRequestError{fmt.Errorf("applying merge patch error: %w", err)}
+			}
+			if err := json.Unmarshal(merged, &s); err != nil {
+				return &badRequestError{fmt.Errorf("unmarshalling json error: %w", err)}
+			}
+		}
+
+		// 允许原样提交已有照片, 不能增删或修改
+		samePath := func(a, b Photo) bool { return a.Path == b.Path }
+		if submitted.Photos != nil && !slices.EqualFunc(s.Photos, cur.Photos, samePath) {
+			return &validationError{Fields: map[string]string{"photos": "can only be added by uploading"}}
+		}
+		s.Photos = cur.Photos
+
+		if err := prepareSpecimen(&s, lookup); err != nil {
+			return err
+		}
+		if s.Cover != "" && !slices.ContainsFunc(s.Photos, func(p Photo) bool { return p.Path == s.Cover }) {
+			return &validationError{Fields: map[string]string{"cover": "must be one of the photos"}}
+		}
+		s.ID, s.CreatedAt, s.UpdatedAt = cur.ID, cur.CreatedAt, time.Now()
+
+		*cur = s
+		return nil
+	})
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, viewSpecimen(updated))
+}
+
+// deleteSpecimenHandler 删除收藏及其养护日志
+func deleteSpecimenHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := collection.Delete(id); err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+	if err := journal.Purge("", id); err != nil {
+		log.Printf("failed to purge journal of specimen %s: %v\n", id, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadSpecimenPhotosHandler 为收藏上传照片, 参数同植物照片上传
+func uploadSpecimenPhotosHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := collection.Get(r.PathValue("id"))
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+
+	photos, cover, ok := readPhotoUploads(w, r)
+	if !ok {
+		return
+	}
+
+	// 在 Modify 中合并, 同时上传的照片不会相互覆盖
+	s, err = collection.Modify(s.ID, func(cur *Specimen) error {
+		var added []Photo
+		cur.Photos, added = mergePhotos(cur.Photos, photos, cur.Nickname)
+		if len(added) > 0 && (cover || cur.Cover == "") {
+			cur.Cover = added[0].Path
+		}
+		cur.UpdatedAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, viewSpecimen(s))
+}
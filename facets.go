@@ -0,0 +1,350 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozillazg/go-pinyin"
+)
+
+// 等级类分面按固定顺序输出和排序
+var (
+	toxicityLevels = []string{"无", "低", "中", "高"}
+	lightLevels    = []string{"全日照", "半日照", "无日照"}
+)
+
+// plantFamily 从科属中取出科, 如 "百合科铃兰属" 为 "百合科", 没有科时为空
+func plantFamily(p *Plant) string {
+	family, _, ok := strings.Cut(p.Genus, "科")
+	if !ok || strings.TrimSpace(family) == "" {
+		return ""
+	}
+
+	return strings.TrimSpace(family) + "科"
+}
+
+// floweringMonths 花期所在的月份, 从小到大
+func floweringMonths(p *Plant) []int {
+	months := slices.Clone(p.Months)
+	slices.Sort(months)
+	return months
+}
+
+// facetDef 一个分面: 从植物取值 (可以有多个值) 和值的排序
+type facetDef struct {
+	Name   string
+	Values func(p *Plant) []string
+	Order  []string // 为空时按数量从多到少排序
+}
+
+var facetDefs = []facetDef{
+	{"category", func(p *Plant) []string { return nonEmpty(p.Icategory) }, nil},
+	{"toxicity", func(p *Plant) []string { return nonEmpty(p.Itoxicity) }, toxicityLevels},
+	{"light", func(p *Plant) []string { return nonEmpty(p.Ilight) }, lightLevels},
+	{"month", func(p *Plant) []string {
+		var months []string
+		for _, m := range floweringMonths(p) {
+			months = append(months, strconv.Itoa(m))
+		}
+		return months
+	}, []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12"}},
+	{"family", func(p *Plant) []string { return nonEmpty(plantFamily(p)) }, nil},
+	{"unparsed", func(p *Plant) []string { return p.Unparsed }, []string{"size", "temperature", "period"}},
+}
+
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+// facetCount 分面中一个取值的植物数量
+type facetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// catalogQuery 目录查询参数
+type catalogQuery struct {
+	Query   string              // 搜索词, 非空时只返回命中的植物
+	Filters map[string][]string // 分面 -> 选中的取值, 同一分面内为或, 不同分面之间为且
+	Ranges  map[string]float64  // 数值范围条件, 见 rangeFilters
+	Sort    string
+	Desc    bool
+	Page    int
+	PerPage int
+}
+
+var catalogSorts = []string{"", "relevance", "name", "enname", "family", "toxicity", "light", "flowering"}
+
+const maxPerPage = 1000
+
+// parseCatalogQuery 解析 q, 分面参数 (逗号分隔或重复, month 可以为 now), 数值范围条件, sort, order (asc|desc), page 和 per_page
+func parseCatalogQuery(r *http.Request) (catalogQuery, error) {
+	ranges, err := parseRangeFilters(r)
+	if err != nil {
+		return catalogQuery{}, err
+	}
+
+	q := r.URL.Query()
+	cq := catalogQuery{
+		Query:   strings.TrimSpace(q.Get("q")),
+		Filters: map[string][]string{},
+		Ranges:  ranges,
+		Sort:    q.Get("sort"),
+		Page:    1,
+		PerPage: 20,
+	}
+
+	for _, f := range facetDefs {
+		for _, v := range q[f.Name] {
+			for _, s := range strings.Split(v, ",") {
+				// month=now 为本月开花
+				if s = strings.TrimSpace(s); f.Name == "month" && s == "now" {
+					s = strconv.Itoa(int(time.Now().Month()))
+				}
+				if s != "" {
+					cq.Filters[f.Name] = append(cq.Filters[f.Name], s)
+				}
+			}
+		}
+		if f.Order != nil {
+			for _, s := range cq.Filters[f.Name] {
+				if !slices.Contains(f.Order, s) {
+					return cq, &badRequestError{fmt.Errorf("invalid %s: %s (must be one of %s)", f.Name, s, strings.Join(f.Order, ", "))}
+				}
+			}
+		}
+	}
+
+	if !slices.Contains(catalogSorts, cq.Sort) {
+		return cq, &badRequestError{fmt.Errorf("invalid sort: %s (must be one of %s)", cq.Sort, strings.Join(catalogSorts[1:], ", "))}
+	}
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		cq.Desc = true
+	default:
+		return cq, &badRequestError{fmt.Errorf("invalid order: %s", q.Get("order"))}
+	}
+	for name, dst := range map[string]*int{"page": &cq.Page, "per_page": &cq.PerPage} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return cq, &badRequestError{fmt.Errorf("invalid %s: %s", name, v)}
+			}
+			*dst = n
+		}
+	}
+	cq.PerPage = min(cq.PerPage, maxPerPage)
+	// 防止 (page-1)*per_page 溢出
+	if cq.Page > math.MaxInt/cq.PerPage {
+		return cq, &badRequestError{fmt.Errorf("page out of range: %d", cq.Page)}
+	}
+
+	return cq, nil
+}
+
+// matchFacets 植物是否满足除 skip 以外的所有分面条件
+func (cq *catalogQuery) matchFacets(values map[string][]string, skip string) bool {
+	for name, selected := range cq.Filters {
+		if name == skip {
+			continue
+		}
+		if !slices.ContainsFunc(values[name], func(v string) bool { return slices.Contains(selected, v) }) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// catalogPage 分页后的目录和分面统计
+type catalogPage struct {
+	Total   int                     `json:"total"`
+	Page    int                     `json:"page"`
+	PerPage int                     `json:"per_page"`
+	Pages   int                     `json:"pages"`
+	Items   []*Plant                `json:"items"`
+	Facets  map[string][]facetCount `json:"facets"`
+}
+
+// queryCatalog 过滤, 统计分面并分页; 分面统计不应用该分面自身的条件, 选中一个取值后仍能看到同一分面其他取值的数量
+func queryCatalog(cq catalogQuery) (*catalogPage, error) {
+	plants, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	// 有搜索词时只保留命中的植物, 并记录相关度
+	scores := map[string]float64{}
+	if cq.Query != "" {
+		hits, err := plantIndex.Search(cq.Query, 0)
+		if err != nil {
+			return nil, err
+		}
+		plants = plants[:0]
+		for _, h := range hits {
+			plants = append(plants, h.Plant)
+			scores[h.Plant.ID] = h.Score
+		}
+	}
+
+	// 数值范围条件与搜索词一样先于分面统计应用
+	if len(cq.Ranges) > 0 {
+		plants = slices.DeleteFunc(plants, func(p *Plant) bool { return !matchRanges(p, cq.Ranges) })
+	}
+
+	values := make([]map[string][]string, len(plants))
+	for i, p := range plants {
+		values[i] = map[string][]string{}
+		for _, f := range facetDefs {
+			values[i][f.Name] = f.Values(p)
+		}
+	}
+
+	page := &catalogPage{Page: cq.Page, PerPage: cq.PerPage, Items: []*Plant{}, Facets: map[string][]facetCount{}}
+	for _, f := range facetDefs {
+		counts := map[string]int{}
+		for i := range plants {
+			if cq.matchFacets(values[i], f.Name) {
+				for _, v := range values[i][f.Name] {
+					counts[v]++
+				}
+			}
+		}
+		page.Facets[f.Name] = sortFacet(f, counts)
+	}
+
+	var matched []*Plant
+	for i, p := range plants {
+		if cq.matchFacets(values[i], "") {
+			matched = append(matched, p)
+		}
+	}
+	sortCatalog(matched, cq, scores)
+
+	page.Total = len(matched)
+	page.Pages = (page.Total + cq.PerPage - 1) / cq.PerPage
+	if start := (cq.Page - 1) * cq.PerPage; start < len(matched) {
+		page.Items = matched[start:min(start+cq.PerPage, len(matched))]
+	}
+
+	return page, nil
+}
+
+func sortFacet(f facetDef, counts map[string]int) []facetCount {
+	res := []facetCount{}
+	if f.Order != nil {
+		for _, v := range f.Order {
+			if counts[v] > 0 {
+				res = append(res, facetCount{Value: v, Count: counts[v]})
+			}
+		}
+		return res
+	}
+
+	for v, n := range counts {
+		res = append(res, facetCount{Value: v, Count: n})
+	}
+	slices.SortFunc(res, func(a, b facetCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return cmp.Compare(pinyinKey(a.Value), pinyinKey(b.Value))
+	})
+
+	return res
+}
+
+// pinyinKey 中文按拼音排序的键, 非汉字原样保留
+func pinyinKey(s string) string {
+	a := pinyin.NewArgs()
+	a.Fallback = func(r rune, a pinyin.Args) []string { return []string{strings.ToLower(string(r))} }
+	return strings.Join(pinyin.LazyPinyin(s, a), " ")
+}
+
+// levelIndex 等级在顺序中的位置, 未知等级排在最后
+func levelIndex(levels []string, v string) int {
+	if i := slices.Index(levels, v); i >= 0 {
+		return i
+	}
+	return len(levels)
+}
+
+// sortCatalog 按 sort 排序, 为空时有搜索词按相关度, 否则保持目录顺序; 相同时按中文名拼音
+func sortCatalog(plants []*Plant, cq catalogQuery, scores map[string]float64) {
+	by := cq.Sort
+	if by == "" && cq.Query != "" {
+		by = "relevance"
+	}
+	if by == "" {
+		if cq.Desc {
+			slices.Reverse(plants)
+		}
+		return
+	}
+
+	keys := make(map[string]string, len(plants))
+	for _, p := range plants {
+		keys[p.ID] = pinyinKey(p.Cnname)
+	}
+	// 按花期开始的月份排序, "12月至次年4月" 从 12 月开始
+	firstMonth := func(p *Plant) int {
+		if len(p.Months) > 0 {
+			return p.Months[0]
+		}
+		return 13
+	}
+
+	slices.SortStableFunc(plants, func(a, b *Plant) int {
+		var c int
+		switch by {
+		case "name":
+			c = cmp.Compare(keys[a.ID], keys[b.ID])
+		case "relevance":
+			c = cmp.Compare(scores[b.ID], scores[a.ID])
+		case "enname":
+			c = cmp.Compare(strings.ToLower(a.Enname), strings.ToLower(b.Enname))
+		case "family":
+			c = cmp.Compare(pinyinKey(plantFamily(a)), pinyinKey(plantFamily(b)))
+		case "toxicity":
+			c = cmp.Compare(levelIndex(toxicityLevels, a.Itoxicity), levelIndex(toxicityLevels, b.Itoxicity))
+		case "light":
+			c = cmp.Compare(levelIndex(lightLevels, a.Ilight), levelIndex(lightLevels, b.Ilight))
+		case "flowering":
+			c = cmp.Compare(firstMonth(a), firstMonth(b))
+		}
+		if cq.Desc {
+			c = -c
+		}
+		if c == 0 {
+			c = cmp.Compare(keys[a.ID], keys[b.ID])
+		}
+		return c
+	})
+}
+
+// catalogHandler 分面浏览目录: 分面过滤, 搜索, 排序和分页, 同时返回各分面取值的数量
+func catalogHandler(w http.ResponseWriter, r *http.Request) {
+	cq, err := parseCatalogQuery(r)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	page, err := queryCatalog(cq)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestParseCatalogQueryPage(t *testing.T) {
+	tests := []struct {
+		query   string
+		page    int
+		perPage int
+		bad     bool
+	}{
+		{"", 1, 20, false},
+		{"page=3&per_page=50", 3, 50, false},
+		{"per_page=5000", 1, maxPerPage, false},
+		{"page=0", 0, 0, true},
+		{"page=abc", 0, 0, true},
+		{"page=" + strconv.Itoa(math.MaxInt), 0, 0, true},
+		{"page=" + strconv.Itoa(math.MaxInt/100+1) + "&per_page=100", 0, 0, true},
+		{"page=" + strconv.Itoa(math.MaxInt/100) + "&per_page=100", math.MaxInt / 100, 100, false},
+	}
+	for _, tt := range tests {
+		cq, err := parseCatalogQuery(httptest.NewRequest("GET", "/api/catalog?"+tt.query, nil))
+		var bre *badRequestError
+		if tt.bad {
+			if !errors.As(err, &bre) {
+				t.Errorf("parseCatalogQuery(%q) error = %v, want bad request", tt.query, err)
+			}
+			continue
+		}
+		if err != nil || cq.Page != tt.page || cq.PerPage != tt.perPage {
+			t.Errorf("parseCatalogQuery(%q) = page %d per_page %d, %v; want %d %d", tt.query, cq.Page, cq.PerPage, err, tt.page, tt.perPage)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errCircuitOpen = errors.New("circuit open, provider temporarily disabled")
+
+// httpStatusError 大模型接口返回的非 200 响应
+type httpStatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("api request failed with status code %d: %s", e.StatusCode, e.Body)
+}
+
+// retryable 限流和服务端错误可以重试, 其余 4xx (如鉴权失败) 直接切换下一个 provider
+func (e *httpStatusError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusRequestTimeout || e.StatusCode >= 500
+}
+
+// parseRetryAfter 解析 Retry-After 头, 支持秒数和 HTTP 日期两种格式
+func parseRetryAfter(v string) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+
+	return 0
+}
+
+// streamError 流式回答中途失败, 已输出的内容无法撤回, 不再重试
+type streamError struct {
+	err error
+}
+
+func (e *streamError) Error() string {
+	return "stream interrupted after partial output, not retrying: " + e.err.Error()
+}
+
+func (e *streamError) Unwrap() error {
+	return e.err
+}
+
+// providerError 单个 provider 的最终失败原因
+type providerError struct {
+	Provider string `json:"provider"`
+	Error    string `json:"error"`
+}
+
+// failoverError 所有 provider 都失败时返回, Errors 按尝试顺序排列
+type failoverError struct {
+	Errors []providerError
+}
+
+func (e *failoverError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, pe := range e.Errors {
+		msgs = append(msgs, pe.Provider+": "+pe.Error)
+	}
+
+	return "all llm providers failed: " + strings.Join(msgs, "; ")
+}
+
+// guardedProvider 为单个 provider 增加重试, 指数退避和熔断
+type guardedProvider struct {
+	LLMProvider
+
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	threshold  int
+	cooldown   time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool // 半开状态下已放行一个试探请求, 结束前拒绝其余请求
+}
+
+func newGuardedProvider(p LLMProvider, cfg providerConfig) *guardedProvider {
+	g := &guardedProvider{
+		LLMProvider: p,
+		retries:     2,
+		backoff:     500 * time.Millisecond,
+		maxBackoff:  10 * time.Second,
+		threshold:   5,
+		cooldown:    time.Minute,
+	}
+	if cfg.Retries != nil {
+		g.retries = max(*cfg.Retries, 0)
+	}
+	if cfg.Backoff > 0 {
+		g.backoff = time.Duration(cfg.Backoff)
+	}
+	if cfg.BreakerThreshold > 0 {
+		g.threshold = cfg.BreakerThreshold
+	}
+	if cfg.BreakerCooldown > 0 {
+		g.cooldown = time.Duration(cfg.BreakerCooldown)
+	}
+
+	return g
+}
+
+// allow 熔断打开期间拒绝请求, 冷却结束后只放行一个试探请求 (半开), 试探成功前熔断保持打开.
+// probe 表示放行的是试探请求, 需要在 record 时传回
+func (g *guardedProvider) allow() (ok, probe bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.failures < g.threshold {
+		return true, false
+	}
+	if g.probing || time.Now().Before(g.openUntil) {
+		return false, false
+	}
+	g.probing = true
+
+	return true, true
+}
+
+func (g *guardedProvider) record(err error, probe bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if probe {
+		g.probing = false
+	}
+	// 调用方取消或超时不是 provider 的故障
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	if err == nil {
+		g.failures = 0
+		return
+	}
+
+	g.failures++
+	if g.failures >= g.threshold {
+		g.openUntil = time.Now().Add(g.cooldown)
+		log.Printf("llm provider %s circuit opened for %s after %d failures\n", g.Name(), g.cooldown, g.failures)
+	}
+}
+
+// wait 计算第 attempt 次重试前的等待时间: 带抖动的指数退避, 服务端给出 Retry-After 时以其为准
+func (g *guardedProvider) wait(attempt int, err error) time.Duration {
+	d := min(g.backoff<<attempt, g.maxBackoff)
+	d = d/2 + rand.N(d/2+1)
+
+	var serr *httpStatusError
+	if errors.As(err, &serr) && serr.RetryAfter > d {
+		d = serr.RetryAfter
+	}
+
+	return d
+}
+
+// trackDeltas 包装 OnDelta, 记录是否已向调用方输出过回答. 输出过之后再重试或切换 provider,
+// 调用方会看到重复的内容, 只能直接返回错误
+func trackDeltas(req *chatRequest) *atomic.Bool {
+	streamed := &atomic.Bool{}
+	if onDelta := req.OnDelta; onDelta != nil {
+		req.OnDelta = func(delta string) {
+			streamed.Store(true)
+			onDelta(delta)
+		}
+	}
+
+	return streamed
+}
+
+func (g *guardedProvider) Chat(ctx context.Context, req chatRequest) (content string, err error) {
+	ok, probe := g.allow()
+	if !ok {
+		return "", errCircuitOpen
+	}
+	// 每次调用只记录一次结果, 重试不重复计入失败次数
+	defer func() { g.record(err, probe) }()
+
+	streamed := trackDeltas(&req)
+	for attempt := 0; attempt <= g.retries; attempt++ {
+		content, err = g.LLMProvider.Chat(ctx, req)
+		if err == nil {
+			return content, nil
+		}
+
+		var serr *httpStatusError
+		if errors.As(err, &serr) && !serr.retryable() {
+			return "", err
+		}
+		if streamed.Load() {
+			return "", &streamError{err}
+		}
+		if attempt == g.retries {
+			break
+		}
+
+		d := g.wait(attempt, err)
+		// 等待时间过长时不再重试, 直接切换下一个 provider
+		if d > g.maxBackoff*3 {
+			log.Printf("llm provider %s asks to retry after %s, failing over\n", g.Name(), d)
+			break
+		}
+
+		log.Printf("llm provider %s failed (attempt %d): %v, retrying in %s\n", g.Name(), attempt+1, err, d)
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(d):
+		}
+	}
+
+	return "", err
+}
+
+// failoverProvider 按配置顺序依次尝试各个 provider, 直到有一个成功
+type failoverProvider struct {
+	providers []*guardedProvider
+}
+
+func (f *failoverProvider) Name() string {
+	names := make([]string, 0, len(f.providers))
+	for _, p := range f.providers {
+		names = append(names, p.Name())
+	}
+
+	return strings.Join(names, ",")
+}
+
+func (f *failoverProvider) Chat(ctx context.Context, req chatRequest) (string, error) {
+	streamed := trackDeltas(&req)
+
+	ferr := &failoverError{}
+	for _, p := range f.providers {
+		content, err := p.Chat(ctx, req)
+		if err == nil {
+			return content, nil
+		}
+
+		log.Printf("llm provider %s failed: %v\n", p.Name(), err)
+		ferr.Errors = append(ferr.Errors, providerError{Provider: p.Name(), Error: err.Error()})
+
+		if ctx.Err() != nil || streamed.Load() {
+			break
+		}
+	}
+
+	return "", ferr
+}
+
+func newFailoverProvider(cfgs []providerConfig) (*failoverProvider, error) {
+	f := &failoverProvider{}
+	for _, cfg := range cfgs {
+		p, err := newProvider(cfg)
+		if err != nil {
+			return nil, err
+		}
+		f.providers = append(f.providers, newGuardedProvider(p, cfg))
+	}
+
+	return f, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		in       string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"abc", 0, 0},
+		{"0", 0, 0},
+		{"-3", 0, 0},
+		{"120", 120 * time.Second, 120 * time.Second},
+		{" 5 ", 5 * time.Second, 5 * time.Second},
+		{time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat), 28 * time.Second, 30 * time.Second},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.in); got < tt.min || got > tt.max {
+			t.Errorf("parseRetryAfter(%q) = %s, want between %s and %s", tt.in, got, tt.min, tt.max)
+		}
+	}
+}
+
+// fakeProvider 按顺序返回预设的错误, 用完后成功
+type fakeProvider struct {
+	mu     sync.Mutex
+	errs   []error
+	calls  int
+	deltas []string
+	block  chan struct{} // 非空时 Chat 等待其关闭
+}
+
+func (f *fakeProvider) Name() string { return "fake" }
+
+func (f *fakeProvider) Chat(ctx context.Context, req chatRequest) (string, error) {
+	if f.block != nil {
+		<-f.block
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	if req.OnDelta != nil {
+		for _, d := range f.deltas {
+			req.OnDelta(d)
+		}
+	}
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return "", err
+	}
+	return "ok", nil
+}
+
+func newTestGuard(p LLMProvider, retries int) *guardedProvider {
+	return &guardedProvider{
+		LLMProvider: p, retries: retries, backoff: time.Millisecond, maxBackoff: time.Millisecond,
+		threshold: 2, cooldown: 20 * time.Millisecond,
+	}
+}
+
+func TestGuardedProviderRetries(t *testing.T) {
+	errServer := &httpStatusError{StatusCode: http.StatusServiceUnavailable}
+	errAuth := &httpStatusError{StatusCode: http.StatusUnauthorized}
+
+	tests := []struct {
+		name    string
+		errs    []error
+		retries int
+		calls   int
+		ok      bool
+	}{
+		{"success", nil, 2, 1, true},
+		{"retry then success", []error{errServer}, 2, 2, true},
+		{"retries exhausted", []error{errServer, errServer, errServer}, 2, 3, false},
+		{"not retryable", []error{errAuth}, 2, 1, false},
+		{"no retries", []error{errServer}, 0, 1, false},
+	}
+	for _, tt := range tests {
+		f := &fakeProvider{errs: tt.errs}
+		g := newTestGuard(f, tt.retries)
+		g.threshold = 10
+		_, err := g.Chat(context.Background(), chatRequest{})
+		if (err == nil) != tt.ok || f.calls != tt.calls {
+			t.Errorf("%s: err = %v, calls = %d, want ok %v, calls %d", tt.name, err, f.calls, tt.ok, tt.calls)
+		}
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	errServer := &httpStatusError{StatusCode: http.StatusServiceUnavailable}
+	f := &fakeProvider{errs: []error{errServer, errServer, errServer}}
+	g := newTestGuard(f, 0)
+
+	// 连续失败达到阈值后熔断
+	for range 2 {
+		g.Chat(context.Background(), chatRequest{})
+	}
+	if _, err := g.Chat(context.Background(), chatRequest{}); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("err = %v, want errCircuitOpen", err)
+	}
+
+	// 冷却后试探失败, 熔断重新打开
+	time.Sleep(30 * time.Millisecond)
+	if _, err := g.Chat(context.Background(), chatRequest{}); err == nil || errors.Is(err, errCircuitOpen) {
+		t.Fatalf("probe err = %v, want the provider error", err)
+	}
+	if _, err := g.Chat(context.Background(), chatRequest{}); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("after failed probe err = %v, want errCircuitOpen", err)
+	}
+
+	// 冷却后只放行一个试探请求, 其余请求在试探结束前被拒绝
+	time.Sleep(30 * time.Millisecond)
+	f.block = make(chan struct{})
+	var wg sync.WaitGroup
+	var rejected atomic.Int32
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := g.Chat(context.Background(), chatRequest{}); errors.Is(err, errCircuitOpen) {
+				rejected.Add(1)
+			}
+		}()
+	}
+	for deadline := time.Now().Add(time.Second); rejected.Load() < 4 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	close(f.block)
+	wg.Wait()
+	if rejected.Load() != 4 || f.calls != 4 {
+		t.Errorf("rejected %d concurrent requests during the probe with %d calls, want 4 rejected and 4 calls", rejected.Load(), f.calls)
+	}
+
+	// 试探成功后熔断关闭
+	if _, err := g.Chat(context.Background(), chatRequest{}); err != nil {
+		t.Errorf("after successful probe err = %v, want nil", err)
+	}
+}
+
+func TestBreakerCountsCalls(t *testing.T) {
+	errServer := &httpStatusError{StatusCode: http.StatusServiceUnavailable}
+
+	// 一次调用内的多次重试只记一次失败
+	f := &fakeProvider{errs: []error{errServer, errServer, errServer}}
+	g := newTestGuard(f, 2)
+	if _, err := g.Chat(context.Background(), chatRequest{}); err == nil {
+		t.Fatal("err = nil, want the provider error")
+	}
+	if g.failures != 1 {
+		t.Errorf("failures = %d after one call with 3 attempts, want 1", g.failures)
+	}
+
+	// 调用方取消不计入失败
+	f = &fakeProvider{errs: []error{context.Canceled, context.DeadlineExceeded}}
+	g = newTestGuard(f, 0)
+	for range 2 {
+		g.Chat(context.Background(), chatRequest{})
+	}
+	if g.failures != 0 {
+		t.Errorf("failures = %d after cancelled calls, want 0", g.failures)
+	}
+	if _, err := g.Chat(context.Background(), chatRequest{}); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+}
+
+func TestFailoverProvider(t *testing.T) {
+	errServer := &httpStatusError{StatusCode: http.StatusServiceUnavailable}
+	first := &fakeProvider{errs: []error{errServer}}
+	second := &fakeProvider{}
+	f := &failoverProvider{providers: []*guardedProvider{newTestGuard(first, 0), newTestGuard(second, 0)}}
+
+	if content, err := f.Chat(context.Background(), chatRequest{}); err != nil || content != "ok" {
+		t.Fatalf("Chat = %q, %v, want ok", content, err)
+	}
+	if first.calls != 1 || second.calls != 1 {
+		t.Errorf("calls = %d, %d, want 1, 1", first.calls, second.calls)
+	}
+
+	first.errs, second.errs = []error{errServer}, []error{errServer}
+	var ferr *failoverError
+	if _, err := f.Chat(context.Background(), chatRequest{}); !errors.As(err, &ferr) || len(ferr.Errors) != 2 {
+		t.Errorf("err = %v, want a failoverError with 2 providers", err)
+	}
+}
+
+func TestNoRetryAfterStreamedOutput(t *testing.T) {
+	errServer := &httpStatusError{StatusCode: http.StatusServiceUnavailable}
+	first := &fakeProvider{errs: []error{errServer, errServer}, deltas: []string{"{\"cn"}}
+	second := &fakeProvider{deltas: []string{"{\"cnname\":\"绿萝\"}"}}
+	guard := newTestGuard(first, 2)
+	guard.threshold = 10
+	f := &failoverProvider{providers: []*guardedProvider{guard, newTestGuard(second, 0)}}
+
+	var out string
+	_, err := f.Chat(context.Background(), chatRequest{OnDelta: func(d string) { out += d }})
+	if err == nil || !strings.Contains(err.Error(), "stream interrupted") {
+		t.Errorf("err = %v, want a stream interrupted error", err)
+	}
+	if first.calls != 1 || second.calls != 0 || out != "{\"cn" {
+		t.Errorf("calls = %d, %d, output %q, want a single attempt without failover", first.calls, second.calls, out)
+	}
+
+	// 没有输出过内容时仍然重试和切换
+	first.deltas, first.errs, first.calls = nil, []error{errServer, errServer, errServer}, 0
+	out = ""
+	if _, err := f.Chat(context.Background(), chatRequest{OnDelta: func(d string) { out += d }}); err != nil {
+		t.Errorf("err = %v, want failover to succeed", err)
+	}
+	if first.calls != 3 || second.calls != 1 || out != "{\"cnname\":\"绿萝\"}" {
+		t.Errorf("calls = %d, %d, output %q, want 3 attempts then failover", first.calls, second.calls, out)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// floweringEntry 花期日历中的一项: 目录中的植物, 或我的植物中的一株
+type floweringEntry struct {
+	PlantID    string `json:"plant_id"`
+	SpecimenID string `json:"specimen_id,omitempty"`
+	Name       string `json:"name"`
+	Location   string `json:"location,omitempty"`
+	Image      string `json:"image,omitempty"`
+	Period     string `json:"period"`
+	Months     []int  `json:"months"` // 按开花先后排列
+}
+
+// floweringCalendar 按月份的花期日历
+type floweringCalendar struct {
+	Source  string           `json:"source"`
+	Month   int              `json:"month"`   // 筛选的月份, 0 为全年
+	Current int              `json:"current"` // 当前月份
+	Counts  [12]int          `json:"counts"`  // 每月开花的数量 (不受 month 筛选影响), 下标 0 为 1 月
+	Unknown int              `json:"unknown"` // 花期未填写或无法解析的数量
+	Entries []floweringEntry `json:"entries"`
+}
+
+// parseMonth 解析月份参数: 1-12, 或 now 表示当前月份
+func parseMonth(v string, now time.Time) (int, error) {
+	if v == "now" {
+		return int(now.Month()), nil
+	}
+	m, err := strconv.Atoi(v)
+	if err != nil || m < 1 || m > 12 {
+		return 0, &badRequestError{fmt.Errorf("invalid month: %s (must be 1-12 or now)", v)}
+	}
+	return m, nil
+}
+
+// floweringEntries 目录中的植物, 或我的植物中位于 location 的植株 (location 为空时为全部)
+func floweringEntries(source, location string) ([]floweringEntry, error) {
+	var entries []floweringEntry
+	if source == "catalog" {
+		plants, err := store.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range plants {
+			entries = append(entries, floweringEntry{PlantID: p.ID, Name: p.Cnname, Image: p.Image, Period: p.Period, Months: p.Months})
+		}
+		return entries, nil
+	}
+
+	specimens, err := collection.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range specimens {
+		if location != "" && s.Location != location {
+			continue
+		}
+		p, err := store.Get(s.PlantID)
+		if err != nil {
+			return nil, err
+		}
+		image := s.Cover
+		if image == "" {
+			image = p.Image
+		}
+		entries = append(entries, floweringEntry{
+			PlantID: p.ID, SpecimenID: s.ID, Name: s.Nickname, Location: s.Location,
+			Image: image, Period: p.Period, Months: p.Months,
+		})
+	}
+
+	return entries, nil
+}
+
+// floweringHandler 花期日历: source 为 catalog (默认) 或 collection, collection 可按 location 过滤;
+// month 为 1-12 或 now 时只返回该月开花的植物. 按花期开始的月份排序, 相同时按名称拼音
+func floweringHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	now := time.Now()
+	fc := &floweringCalendar{Source: q.Get("source"), Current: int(now.Month()), Entries: []floweringEntry{}}
+	switch fc.Source {
+	case "":
+		fc.Source = "catalog"
+	case "catalog", "collection":
+	default:
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid source: "+fc.Source+" (must be catalog or collection)", nil)
+		return
+	}
+	if v := strings.TrimSpace(q.Get("month")); v != "" {
+		m, err := parseMonth(v, now)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		fc.Month = m
+	}
+
+	entries, err := floweringEntries(fc.Source, strings.TrimSpace(q.Get("location")))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	for _, e := range entries {
+		if len(e.Months) == 0 {
+			fc.Unknown++
+			continue
+		}
+		for _, m := range e.Months {
+			fc.Counts[m-1]++
+		}
+		if fc.Month == 0 || slices.Contains(e.Months, fc.Month) {
+			fc.Entries = append(fc.Entries, e)
+		}
+	}
+
+	keys := map[string]string{}
+	for _, e := range fc.Entries {
+		keys[e.Name] = pinyinKey(e.Name)
+	}
+	slices.SortStableFunc(fc.Entries, func(a, b floweringEntry) int {
+		if c := cmp.Compare(a.Months[0], b.Months[0]); c != 0 {
+			return c
+		}
+		return cmp.Compare(keys[a.Name], keys[b.Name])
+	})
+
+	writeJSON(w, http.StatusOK, fc)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// newID 生成 16 位十六进制的随机 id, 植物改名后 id 保持不变
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// slugify 将英文名转换为 url 友好的 slug, 例如 "Lily of the Valley" -> "lily-of-the-valley"
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+			dash = false
+		} else if b.Len() > 0 && !dash {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+
+	return strings.Trim(b.String(), "-")
+}
+
+// assignID 为缺少 id 或 slug 的植物生成标识, taken 用于判断 slug 是否已被占用
+func assignID(p *Plant, taken func(slug string) bool) {
+	if p.ID == "" {
+		p.ID = newID()
+	}
+	if p.Slug != "" {
+		return
+	}
+
+	slug := slugify(p.Enname)
+	if slug == "" {
+		slug = "plant-" + p.ID[:8]
+	}
+	// 被占用时逐步加长 id 后缀, id 用完后再追加序号
+	base := slug
+	for n := 4; taken(slug) && n <= len(p.ID); n += 4 {
+		slug = base + "-" + p.ID[:n]
+	}
+	for i := 2; taken(slug); i++ {
+		slug = base + "-" + p.ID + "-" + strconv.Itoa(i)
+	}
+	p.Slug = slug
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Lily of the Valley", "lily-of-the-valley"},
+		{"  Monstera deliciosa ", "monstera-deliciosa"},
+		{"Aloe vera 'Variegata'", "aloe-vera-variegata"},
+		{"Sansevieria--trifasciata", "sansevieria-trifasciata"},
+		{"Pothos 2", "pothos-2"},
+		{"Phalænopsis", "phal-nopsis"},
+		{"绿萝", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := slugify(tt.in); got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAssignID(t *testing.T) {
+	taken := func(slug string) bool { return slug == "pothos" }
+
+	p := &Plant{Enname: "Monstera"}
+	assignID(p, taken)
+	if len(p.ID) != 16 || p.Slug != "monstera" {
+		t.Errorf("assignID = %q, %q, want a 16 character id and slug monstera", p.ID, p.Slug)
+	}
+
+	p = &Plant{Enname: "Pothos"}
+	assignID(p, taken)
+	if p.Slug != "pothos-"+p.ID[:4] {
+		t.Errorf("taken slug = %q, want pothos-%s", p.Slug, p.ID[:4])
+	}
+
+	// 带后缀的 slug 也被占用时继续加长
+	p = &Plant{ID: "0123456789abcdef", Enname: "Pothos"}
+	assignID(p, func(slug string) bool { return slug == "pothos" || slug == "pothos-0123" || slug == "pothos-01234567" })
+	if p.Slug != "pothos-0123456789ab" {
+		t.Errorf("taken suffixed slug = %q, want pothos-0123456789ab", p.Slug)
+	}
+	p = &Plant{ID: "0123456789abcdef", Enname: "Pothos"}
+	assignID(p, func(slug string) bool { return !strings.HasSuffix(slug, "-2") })
+	if p.Slug != "pothos-0123456789abcdef-2" {
+		t.Errorf("slug with every id suffix taken = %q, want pothos-0123456789abcdef-2", p.Slug)
+	}
+
+	p = &Plant{Cnname: "绿萝"}
+	assignID(p, taken)
+	if p.Slug != "plant-"+p.ID[:8] {
+		t.Errorf("slug without english name = %q, want plant-%s", p.Slug, p.ID[:8])
+	}
+
+	p = &Plant{ID: "0123456789abcdef", Slug: "kept", Enname: "Pothos"}
+	assignID(p, taken)
+	if p.ID != "0123456789abcdef" || p.Slug != "kept" {
+		t.Errorf("existing identifiers changed to %q, %q", p.ID, p.Slug)
+	}
+}
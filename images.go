@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ImageSource 植物候选图片的来源
+type ImageSource interface {
+	Name() string
+	Fetch(ctx context.Context, plant *Plant) ([]string, error)
+}
+
+// imageSourceConfig 单个图片来源的配置, 可以写在 -image-config 指定的 json 文件中
+type imageSourceConfig struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`     // baidu, iplant, garden, wikimedia, http
+	URL      string `json:"url"`      // 搜索页地址, {cnname} {enname} {name} 替换为植物名
+	Selector string `json:"selector"` // 图片元素的 css 选择器
+	Attr     string `json:"attr"`     // 图片地址所在的属性, 默认依次尝试 src 和 data-src
+	Contains string `json:"contains"` // 只保留包含此字符串的图片地址
+	Limit    int    `json:"limit"`    // 此来源最多返回的图片数
+	Render   *bool  `json:"render"`   // 是否使用浏览器渲染页面
+	Disabled bool   `json:"disabled"`
+}
+
+// imageConfig 图片来源列表按顺序查询, 前面的来源图片不足 MaxImages 时才查询后面的来源; Filter 为图片质量过滤阈值
+type imageConfig struct {
+	MaxImages int                 `json:"max_images"`
+	Sources   []imageSourceConfig `json:"sources"`
+	Filter    imageFilterConfig   `json:"filter"`
+}
+
+var imageSourceDefaults = map[string]imageSourceConfig{
+	"baidu":     {URL: "https://image.baidu.com/search/index?word={cnname}盆栽", Selector: "div#waterfall img", Limit: 4, Render: ptr(true)},
+	"iplant":    {URL: "https://www.iplant.cn/info/{cnname}", Selector: "img", Contains: "/148/", Limit: 4, Render: ptr(true)},
+	"garden":    {URL: "https://garden.org/search/index.php?q={enname}", Selector: "img", Limit: 3, Render: ptr(true)},
+	"wikimedia": {URL: "https://commons.wikimedia.org/w/api.php", Limit: 4},
+	"http":      {Selector: "img", Limit: 4, Render: ptr(false)},
+}
+
+// defaultImageConfig 未指定配置文件时使用百度图片, 不足时以 Wikimedia Commons 补充
+var defaultImageConfig = imageConfig{
+	MaxImages: 4,
+	Sources:   []imageSourceConfig{{Type: "baidu"}, {Type: "wikimedia"}},
+}
+
+var imageSources []ImageSource
+var maxImages int
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func newImageSource(cfg imageSourceConfig) (ImageSource, error) {
+	kind := strings.ToLower(strings.TrimSpace(cfg.Type))
+	if kind == "" {
+		kind = "http"
+	}
+
+	def, ok := imageSourceDefaults[kind]
+	if !ok {
+		return nil, fmt.Errorf("unsupported image source type: %s", cfg.Type)
+	}
+	if cfg.Name == "" {
+		cfg.Name = kind
+	}
+	if cfg.URL == "" {
+		cfg.URL = def.URL
+	}
+	if cfg.Selector == "" {
+		cfg.Selector = def.Selector
+	}
+	if cfg.Contains == "" {
+		cfg.Contains = def.Contains
+	}
+	if cfg.Limit <= 0 {
+		cfg.Limit = def.Limit
+	}
+	if cfg.Render == nil {
+		cfg.Render = def.Render
+	}
+	cfg.Type = kind
+
+	if kind == "wikimedia" {
+		return &wikimediaSource{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	}
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("image source %s: url is required", cfg.Name)
+	}
+
+	return &pageSource{cfg: cfg}, nil
+}
+
+// loadImageSources 读取图片来源配置, path 为空时使用默认配置, 返回的配置已补全默认值
+func loadImageSources(path string) ([]ImageSource, imageConfig, error) {
+	cfg := defaultImageConfig
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, cfg, fmt.Errorf("failed to read image config: %w", err)
+		}
+		cfg = imageConfig{}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, cfg, fmt.Errorf("failed to unmarshal image config: %w", err)
+		}
+	}
+
+	var sources []ImageSource
+	for _, sc := range cfg.Sources {
+		if sc.Disabled {
+			continue
+		}
+		src, err := newImageSource(sc)
+		if err != nil {
+			return nil, cfg, err
+		}
+		sources = append(sources, src)
+	}
+
+	if cfg.MaxImages <= 0 {
+		cfg.MaxImages = defaultImageConfig.MaxImages
+	}
+	filter, err := cfg.Filter.withDefaults()
+	if err != nil {
+		return nil, cfg, err
+	}
+	cfg.Filter = filter
+
+	return sources, cfg, nil
+}
+
+// expandPlantURL 将地址模板中的植物名占位符替换为转义后的名称
+func expandPlantURL(tmpl string, plant *Plant) string {
+	name := plant.Cnname
+	if name == "" {
+		name = plant.Enname
+	}
+	enname := plant.Enname
+	if enname == "" {
+		enname = name
+	}
+
+	return strings.NewReplacer(
+		"{cnname}", url.PathEscape(name),
+		"{enname}", url.PathEscape(enname),
+		"{name}", url.PathEscape(name),
+	).Replace(tmpl)
+}
+
+// imageKey 去重用的图片地址, 忽略协议差异
+func imageKey(src string) string {
+	src = strings.TrimSpace(src)
+	src = strings.TrimPrefix(src, "https:")
+	src = strings.TrimPrefix(src, "http:")
+	return strings.TrimRight(src, "/")
+}
+
+// fetchPlantImages 按顺序查询各个图片来源, 按地址和感知哈希去重并过滤低质量图片, 达到 maxImages 后不再查询后面的来源,
+// onImage 非空时每保留一张图片回调一次
+func fetchPlantImages(ctx context.Context, plant *Plant, onImage func(src string)) []string {
+	var images []string
+	var hashes []uint64
+	seen := map[string]bool{}
+
+	for _, src := range imageSources {
+		if len(images) >= maxImages || ctx.Err() != nil {
+			break
+		}
+
+		log.Printf("fetching %s plant images from %s\n", plant.Cnname, src.Name())
+		found, err := src.Fetch(ctx, plant)
+		if err != nil {
+			log.Printf("failed to fetch %s plant images from %s: %v\n", plant.Cnname, src.Name(), err)
+			continue
+		}
+
+		var candidates []string
+		for _, image := range found {
+			key := imageKey(image)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			candidates = append(candidates, image)
+		}
+
+		var data [][]byte
+		candidates, data, hashes = filterImages(ctx, plant, candidates, hashes)
+		for i, image := range candidates {
+			if len(images) >= maxImages {
+				break
+			}
+			// 只保存采用的图片, 之后镜像时不用再次下载
+			if media != nil && data[i] != nil {
+				if _, err := media.Save(data[i], image); err != nil {
+					log.Printf("failed to save %s plant image %s: %v\n", plant.Cnname, image, err)
+				}
+			}
+			images = append(images, image)
+			if onImage != nil {
+				onImage(image)
+			}
+		}
+	}
+
+	return images
+}
+
+// pageSource 从搜索结果页面中按选择器提取图片, 需要执行 js 的页面使用浏览器渲染, 其余直接 http 请求
+type pageSource struct {
+	cfg imageSourceConfig
+}
+
+func (s *pageSource) Name() string {
+	return s.cfg.Name
+}
+
+func (s *pageSource) Fetch(ctx context.Context, plant *Plant) ([]string, error) {
+	pageURL := expandPlantURL(s.cfg.URL, plant)
+
+	var docstr string
+	var err error
+	if s.cfg.Render != nil && *s.cfg.Render {
+		docstr, err = htmlbychromedp(ctx, pageURL, s.cfg.Selector)
+	} else {
+		docstr, err = htmlbyhttp(ctx, pageURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 将 HTTP 响应体转换为 goquery 的 Document
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(docstr))
+	if err != nil {
+		return nil, fmt.Errorf("create goquery document error: %w", err)
+	}
+
+	base, _ := url.Parse(pageURL)
+	attrs := []string{"src", "data-src"}
+	if s.cfg.Attr != "" {
+		attrs = []string{s.cfg.Attr}
+	}
+
+	var images []string
+	// 选择图片元素
+	doc.Find(s.cfg.Selector).EachWithBreak(func(i int, sel *goquery.Selection) bool {
+		var src string
+		for _, attr := range attrs {
+			if v, ok := sel.Attr(attr); ok && v != "" && !strings.HasPrefix(v, "data:") {
+				src = v
+				break
+			}
+		}
+		if src == "" || (s.cfg.Contains != "" && !strings.Contains(src, s.cfg.Contains)) {
+			return true
+		}
+
+		// 补全协议相对地址和相对路径
+		if u, err := url.Parse(src); err == nil && base != nil {
+			src = base.ResolveReference(u).String()
+		}
+		images = append(images, src)
+
+		return len(images) < s.cfg.Limit
+	})
+
+	return images, nil
+}
+
+// wikimediaSource 通过 Wikimedia Commons api 搜索植物图片, 优先使用英文学名
+type wikimediaSource struct {
+	cfg    imageSourceConfig
+	client *http.Client
+}
+
+func (s *wikimediaSource) Name() string {
+	return s.cfg.Name
+}
+
+func (s *wikimediaSource) Fetch(ctx context.Context, plant *Plant) ([]string, error) {
+	name := plant.Enname
+	if name == "" {
+		name = plant.Cnname
+	}
+
+	q := url.Values{}
+	q.Set("action", "query")
+	q.Set("format", "json")
+	q.Set("generator", "search")
+	q.Set("gsrsearch", "filetype:bitmap "+name)
+	q.Set("gsrnamespace", "6") // File 命名空间
+	q.Set("gsrlimit", fmt.Sprint(s.cfg.Limit))
+	q.Set("prop", "imageinfo")
+	q.Set("iiprop", "url")
+	q.Set("iiurlwidth", "640")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.cfg.URL+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// Wikimedia 要求请求带有可识别的 User-Agent
+	req.Header.Set("User-Agent", "plant/1.0 (https://github.com/xshrim/plant)")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("wikimedia api status error: %s", resp.Status)
+	}
+
+	var result struct {
+		Query struct {
+			Pages map[string]struct {
+				Index     int `json:"index"`
+				ImageInfo []struct {
+					URL      string `json:"url"`
+					ThumbURL string `json:"thumburl"`
+				} `json:"imageinfo"`
+			} `json:"pages"`
+		} `json:"query"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode wikimedia response: %w", err)
+	}
+
+	type ranked struct {
+		index int
+		src   string
+	}
+	var found []ranked
+	for _, page := range result.Query.Pages {
+		if len(page.ImageInfo) == 0 {
+			continue
+		}
+		src := page.ImageInfo[0].ThumbURL
+		if src == "" {
+			src = page.ImageInfo[0].URL
+		}
+		found = append(found, ranked{page.Index, src})
+	}
+	// pages 为 map, 按搜索结果顺序排序
+	sort.Slice(found, func(i, j int) bool { return found[i].index < found[j].index })
+
+	images := make([]string, 0, len(found))
+	for _, f := range found {
+		images = append(images, f.src)
+	}
+
+	return images, nil
+}
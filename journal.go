@@ -0,0 +1,666 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errEventNotFound = errors.New("care event not found")
+
+// errPurgeUnscoped Purge 必须指定植物或收藏, 不允许清空全部日志
+var errPurgeUnscoped = errors.New("purge requires a plant id or specimen id")
+
+const (
+	careRepotting = "repotting"
+	carePruning   = "pruning"
+	carePest      = "pest"
+	careNote      = "note"
+)
+
+// maxAdherenceDays 统计养护完成度的最长天数
+const maxAdherenceDays = 3650
+
+// eventKinds 养护日志支持的事件类型, 浇水和施肥同时更新养护计划的最近完成时间
+var eventKinds = []string{careWatering, careFertilization, careRepotting, carePruning, carePest, careNote}
+
+// CareEvent 养护日志中的一条记录, 记录在目录植物上, 也可以具体到收藏中的某一株
+type CareEvent struct {
+	ID         string    `json:"id"`
+	PlantID    string    `json:"plant_id"`
+	SpecimenID string    `json:"specimen_id,omitempty"`
+	Kind       string    `json:"kind"`
+	At         time.Time `json:"at"` // 养护发生的时间
+	Notes      string    `json:"notes,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// journalFilter 日志查询条件, 为零值的条件不过滤
+type journalFilter struct {
+	PlantID    string
+	SpecimenID string
+	Kinds      []string
+	From       time.Time // 包含
+	To         time.Time // 不包含
+	Limit      int
+}
+
+func (f *journalFilter) match(e *CareEvent) bool {
+	return (f.PlantID == "" || e.PlantID == f.PlantID) &&
+		(f.SpecimenID == "" || e.SpecimenID == f.SpecimenID) &&
+		(len(f.Kinds) == 0 || slices.Contains(f.Kinds, e.Kind)) &&
+		(f.From.IsZero() || !e.At.Before(f.From)) &&
+		(f.To.IsZero() || e.At.Before(f.To))
+}
+
+// JournalStore 养护日志的存储, 与收藏一样跟随植物目录的后端, List 按发生时间倒序返回
+type JournalStore interface {
+	Get(id string) (*CareEvent, error)
+	List(f journalFilter) ([]*CareEvent, error)
+	Add(e *CareEvent) error
+	Delete(id string) error
+	Purge(plantID, specimenID string) error // 删除植物或收藏的全部日志
+}
+
+var journal JournalStore
+
+// openJournal 打开与植物目录同一后端的养护日志, path 仅用于 json 后端
+func openJournal(catalog PlantStore, path string) (JournalStore, error) {
+	if s, ok := catalog.(*sqliteStore); ok {
+		return openSQLiteJournal(s.db)
+	}
+	if path == "" {
+		path = "journal.json"
+	}
+
+	return openJSONJournal(path)
+}
+
+// jsonJournal 将日志按发生时间倒序保存在一个 json 文件中, 每次修改整体重写
+type jsonJournal struct {
+	mu     sync.RWMutex
+	path   string
+	events []*CareEvent
+}
+
+func openJSONJournal(path string) (*jsonJournal, error) {
+	j := &jsonJournal{path: path, events: []*CareEvent{}}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return j, nil
+		}
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	if err := json.Unmarshal(data, &j.events); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal journal: %w", err)
+	}
+	sortEvents(j.events)
+
+	return j, nil
+}
+
+func sortEvents(events []*CareEvent) {
+	slices.SortStableFunc(events, func(a, b *CareEvent) int { return b.At.Compare(a.At) })
+}
+
+func (j *jsonJournal) Get(id string) (*CareEvent, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	idx := slices.IndexFunc(j.events, func(e *CareEvent) bool { return e.ID == id })
+	if idx < 0 {
+		return nil, errEventNotFound
+	}
+	e := *j.events[idx]
+
+	return &e, nil
+}
+
+func (j *jsonJournal) List(f journalFilter) ([]*CareEvent, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	res := []*CareEvent{}
+	for _, e := range j.events {
+		if f.Limit > 0 && len(res) >= f.Limit {
+			break
+		}
+		if f.match(e) {
+			c := *e
+			res = append(res, &c)
+		}
+	}
+
+	return res, nil
+}
+
+func (j *jsonJournal) Add(e *CareEvent) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	c := *e
+	events := append(slices.Clone(j.events), &c)
+	sortEvents(events)
+
+	return j.flush(events)
+}
+
+func (j *jsonJournal) Delete(id string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	idx := slices.IndexFunc(j.events, func(e *CareEvent) bool { return e.ID == id })
+	if idx < 0 {
+		return errEventNotFound
+	}
+
+	return j.flush(slices.Delete(slices.Clone(j.events), idx, idx+1))
+}
+
+func (j *jsonJournal) Purge(plantID, specimenID string) error {
+	if plantID == "" && specimenID == "" {
+		return errPurgeUnscoped
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	f := journalFilter{PlantID: plantID, SpecimenID: specimenID}
+	events := slices.DeleteFunc(slices.Clone(j.events), f.match)
+	if len(events) == len(j.events) {
+		return nil
+	}
+
+	return j.flush(events)
+}
+
+// flush 写入文件成功后才替换内存中的数据, 调用方需持有锁
+func (j *jsonJournal) flush(events []*CareEvent) error {
+	data, err := json.MarshalIndent(events, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(j.path, data, nil); err != nil {
+		return err
+	}
+	j.events = events
+
+	return nil
+}
+
+// sqliteJournal 与植物目录共用数据库连接, at 保存为纳秒时间戳用于排序和范围查询
+type sqliteJournal struct {
+	db *sql.DB
+}
+
+func openSQLiteJournal(db *sql.DB) (*sqliteJournal, error) {
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS care_events (
+	id          TEXT NOT NULL PRIMARY KEY,
+	plant_id    TEXT NOT NULL,
+	specimen_id TEXT NOT NULL DEFAULT '',
+	kind        TEXT NOT NULL,
+	at          INTEGER NOT NULL,
+	data        TEXT NOT NULL
+)`,
+		`CREATE INDEX IF NOT EXISTS idx_care_events_plant_id ON care_events (plant_id, at)`,
+		`CREATE INDEX IF NOT EXISTS idx_care_events_specimen_id ON care_events (specimen_id, at)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("failed to migrate journal: %w", err)
+		}
+	}
+
+	return &sqliteJournal{db: db}, nil
+}
+
+func (j *sqliteJournal) Get(id string) (*CareEvent, error) {
+	var data string
+	err := j.db.QueryRow(`SELECT data FROM care_events WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errEventNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var e CareEvent
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal json: %w", err)
+	}
+
+	return &e, nil
+}
+
+func (j *sqliteJournal) List(f journalFilter) ([]*CareEvent, error) {
+	var conds []string
+	var args []any
+	if f.PlantID != "" {
+		conds, args = append(conds, "plant_id = ?"), append(args, f.PlantID)
+	}
+	if f.SpecimenID != "" {
+		conds, args = append(conds, "specimen_id = ?"), append(args, f.SpecimenID)
+	}
+	if len(f.Kinds) > 0 {
+		conds = append(conds, "kind IN (?"+strings.Repeat(", ?", len(f.Kinds)-1)+")")
+		for _, k := range f.Kinds {
+			args = append(args, k)
+		}
+	}
+	if !f.From.IsZero() {
+		conds, args = append(conds, "at >= ?"), append(args, f.From.UnixNano())
+	}
+	if !f.To.IsZero() {
+		conds, args = append(conds, "at < ?"), append(args, f.To.UnixNano())
+	}
+
+	query := `SELECT data FROM care_events`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+	query += ` ORDER BY at DESC, rowid`
+	if f.Limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(f.Limit)
+	}
+
+	rows, err := j.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*CareEvent{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var e CareEvent
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal json: %w", err)
+		}
+		res = append(res, &e)
+	}
+
+	return res, rows.Err()
+}
+
+func (j *sqliteJournal) Add(e *CareEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = j.db.Exec(`INSERT INTO care_events (id, plant_id, specimen_id, kind, at, data) VALUES (?, ?, ?, ?, ?, ?)`,
+		e.ID, e.PlantID, e.SpecimenID, e.Kind, e.At.UnixNano(), string(data))
+	return err
+}
+
+func (j *sqliteJournal) Delete(id string) error {
+	res, err := j.db.Exec(`DELETE FROM care_events WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errEventNotFound
+	}
+
+	return nil
+}
+
+func (j *sqliteJournal) Purge(plantID, specimenID string) error {
+	var err error
+	switch {
+	case specimenID != "":
+		_, err = j.db.Exec(`DELETE FROM care_events WHERE specimen_id = ?`, specimenID)
+	case plantID != "":
+		_, err = j.db.Exec(`DELETE FROM care_events WHERE plant_id = ?`, plantID)
+	default:
+		err = errPurgeUnscoped
+	}
+	return err
+}
+
+// prepareEvent 校验日志并解析植物: 填写 specimen_id 时植物取自收藏, 否则 plant_id 可以是 id, slug 或名称
+func prepareEvent(e *CareEvent) error {
+	fields := map[string]string{}
+
+	e.Kind = strings.TrimSpace(e.Kind)
+	e.Notes = strings.TrimSpace(e.Notes)
+
+	if !slices.Contains(eventKinds, e.Kind) {
+		fields["kind"] = "must be one of " + strings.Join(eventKinds, ", ")
+	}
+	if e.Kind == careNote && e.Notes == "" {
+		fields["notes"] = "is required for notes"
+	}
+
+	if e.SpecimenID != "" {
+		if s, err := collection.Get(e.SpecimenID); err != nil {
+			fields["specimen_id"] = err.Error()
+		} else if p, err := store.Get(e.PlantID); e.PlantID != "" && (err != nil || p.ID != s.PlantID) {
+			fields["plant_id"] = "does not match the specimen"
+		} else {
+			e.PlantID = s.PlantID
+		}
+	} else if strings.TrimSpace(e.PlantID) == "" {
+		fields["plant_id"] = "is required without specimen_id"
+	} else if p, err := store.Get(e.PlantID); err != nil {
+		fields["plant_id"] = err.Error()
+	} else {
+		e.PlantID = p.ID
+	}
+
+	if e.At.IsZero() {
+		e.At = time.Now()
+	} else if e.At.After(time.Now().Add(time.Minute)) {
+		fields["at"] = "must not be in the future"
+	}
+
+	if len(fields) > 0 {
+		return &validationError{Fields: fields}
+	}
+
+	return nil
+}
+
+// recordCare 写入一条日志, 浇水和施肥同时更新养护计划的最近完成时间
+func recordCare(e *CareEvent) error {
+	e.ID = newID()
+	e.CreatedAt = time.Now()
+	if err := journal.Add(e); err != nil {
+		return err
+	}
+	if slices.Contains(careKinds, e.Kind) {
+		return cares.Done(e.PlantID, e.Kind, e.At)
+	}
+
+	return nil
+}
+
+// forgetCare 删除一条日志; 删除的是最近一次浇水或施肥时, 最近完成时间回退到日志中剩余的最近一次
+func forgetCare(e *CareEvent) error {
+	if err := journal.Delete(e.ID); err != nil {
+		return err
+	}
+	if !slices.Contains(careKinds, e.Kind) {
+		return nil
+	}
+	if last := cares.Last(e.PlantID, e.Kind); last == nil || !last.Equal(e.At) {
+		return nil
+	}
+
+	events, err := journal.List(journalFilter{PlantID: e.PlantID, Kinds: []string{e.Kind}, Limit: 1})
+	if err != nil {
+		return err
+	}
+	var at *time.Time
+	if len(events) > 0 {
+		at = &events[0].At
+	}
+
+	return cares.Reset(e.PlantID, e.Kind, at)
+}
+
+// backfillJournal 将日志功能出现之前养护记录中的最近完成时间补记为日志, 之后删除日志回退最近完成时间时
+// 不会丢失这些更早的记录
+func backfillJournal() error {
+	n := 0
+	for id, kinds := range cares.Snapshot() {
+		if _, err := store.Get(id); err != nil {
+			if errors.Is(err, errPlantNotFound) {
+				continue
+			}
+			return err
+		}
+		for kind, at := range kinds {
+			events, err := journal.List(journalFilter{PlantID: id, Kinds: []string{kind}, Limit: 1})
+			if err != nil {
+				return err
+			}
+			if len(events) > 0 && !events[0].At.Before(at) {
+				continue
+			}
+			e := &CareEvent{ID: newID(), PlantID: id, Kind: kind, At: at, Notes: "补记自养护记录", CreatedAt: time.Now()}
+			if err := journal.Add(e); err != nil {
+				return err
+			}
+			n++
+		}
+	}
+	if n > 0 {
+		log.Printf("backfilled %d care events from the care log\n", n)
+	}
+
+	return nil
+}
+
+// writeJournalError 日志不存在时返回 404, 其余错误同收藏
+func writeJournalError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errEventNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", err.Error(), nil)
+		return
+	}
+
+	writeCollectionError(w, err)
+}
+
+// parseJournalFilter 解析 plant, specimen, kind (逗号分隔), from, to (YYYY-MM-DD, 包含当天) 和 limit 参数
+func parseJournalFilter(r *http.Request) (journalFilter, error) {
+	q := r.URL.Query()
+	var f journalFilter
+
+	if key := q.Get("plant"); key != "" {
+		p, err := store.Get(key)
+		if err != nil {
+			return f, err
+		}
+		f.PlantID = p.ID
+	}
+	if id := q.Get("specimen"); id != "" {
+		if _, err := collection.Get(id); err != nil {
+			return f, err
+		}
+		f.SpecimenID = id
+	}
+	if v := q.Get("kind"); v != "" {
+		for _, k := range strings.Split(v, ",") {
+			k = strings.TrimSpace(k)
+			if !slices.Contains(eventKinds, k) {
+				return f, &badRequestError{fmt.Errorf("invalid kind: %s", k)}
+			}
+			f.Kinds = append(f.Kinds, k)
+		}
+	}
+	for name, t := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		if v := q.Get(name); v != "" {
+			d, err := time.ParseInLocation(time.DateOnly, v, time.Local)
+			if err != nil {
+				return f, &badRequestError{fmt.Errorf("invalid %s: %s", name, v)}
+			}
+			*t = d
+		}
+	}
+	if !f.To.IsZero() {
+		f.To = f.To.AddDate(0, 0, 1)
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return f, &badRequestError{fmt.Errorf("invalid limit: %s", v)}
+		}
+		f.Limit = n
+	}
+
+	return f, nil
+}
+
+// listJournalHandler 按发生时间倒序列出养护日志
+func listJournalHandler(w http.ResponseWriter, r *http.Request) {
+	f, err := parseJournalFilter(r)
+	if err != nil {
+		writeJournalError(w, err)
+		return
+	}
+
+	events, err := journal.List(f)
+	if err != nil {
+		writeJournalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, events)
+}
+
+func addJournalHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var e CareEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		writeStoreError(w, &badRequestError{fmt.Errorf("unmarshalling json error: %w", err)})
+		return
+	}
+	if err := prepareEvent(&e); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if err := recordCare(&e); err != nil {
+		writeJournalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, e)
+}
+
+func deleteJournalHandler(w http.ResponseWriter, r *http.Request) {
+	e, err := journal.Get(r.PathValue("id"))
+	if err != nil {
+		writeJournalError(w, err)
+		return
+	}
+	if err := forgetCare(e); err != nil {
+		writeJournalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// careAdherence 一段时间内实际浇水或施肥与养护计划的对比
+type careAdherence struct {
+	Kind     string  `json:"kind"`
+	Text     string  `json:"text"`
+	Interval int     `json:"interval"` // 今天所在月份的计划间隔天数, 0 表示暂停
+	Expected float64 `json:"expected"` // 按计划应执行的次数
+	Actual   int     `json:"actual"`   // 日志中实际执行的次数
+	Ratio    float64 `json:"ratio"`    // 实际次数 / 计划次数, 计划全部暂停时为 0
+	AvgGap   float64 `json:"avg_gap"`  // 实际相邻两次的平均间隔天数, 少于两次时为 0
+}
+
+// adherence 按日累加计划频率 (1/间隔) 得到 [from, to) 内应执行的次数, 与日志中的实际次数对比
+func adherence(p *Plant, events []*CareEvent, from, to time.Time) []careAdherence {
+	var res []careAdherence
+	for _, s := range plantSchedules(p) {
+		a := careAdherence{Kind: s.Kind, Text: s.Text, Interval: s.IntervalIn(time.Now().Month())}
+		for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+			if n := s.IntervalIn(d.Month()); n > 0 {
+				a.Expected += 1 / float64(n)
+			}
+		}
+
+		var times []time.Time
+		for _, e := range events {
+			if e.Kind == s.Kind && !e.At.Before(from) && e.At.Before(to) {
+				times = append(times, e.At)
+			}
+		}
+		a.Actual = len(times)
+		if len(times) > 1 {
+			// 日志按时间倒序
+			a.AvgGap = round1(times[0].Sub(times[len(times)-1]).Hours() / 24 / float64(len(times)-1))
+		}
+		if a.Expected > 0 {
+			a.Ratio = round1(float64(a.Actual) / a.Expected)
+		}
+		a.Expected = round1(a.Expected)
+		res = append(res, a)
+	}
+
+	return res
+}
+
+func round1(f float64) float64 {
+	return math.Round(f*10) / 10
+}
+
+// journalAdherenceHandler 对比最近 days (默认 90) 天植物或收藏的浇水施肥日志与养护计划
+func journalAdherenceHandler(w http.ResponseWriter, r *http.Request) {
+	f, err := parseJournalFilter(r)
+	if err != nil {
+		writeJournalError(w, err)
+		return
+	}
+	if f.SpecimenID != "" && f.PlantID == "" {
+		s, err := collection.Get(f.SpecimenID)
+		if err != nil {
+			writeJournalError(w, err)
+			return
+		}
+		f.PlantID = s.PlantID
+	}
+	if f.PlantID == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "plant or specimen is required", nil)
+		return
+	}
+	p, err := store.Get(f.PlantID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	days := 90
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("invalid days: %s", v), nil)
+			return
+		}
+		if n > maxAdherenceDays {
+			writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("days must be at most %d", maxAdherenceDays), nil)
+			return
+		}
+		days = n
+	}
+	to := dateOf(time.Now()).AddDate(0, 0, 1)
+	from := to.AddDate(0, 0, -days)
+
+	events, err := journal.List(journalFilter{PlantID: f.PlantID, SpecimenID: f.SpecimenID, Kinds: careKinds, From: from, To: to})
+	if err != nil {
+		writeJournalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"plant_id":    p.ID,
+		"specimen_id": f.SpecimenID,
+		"from":        from.Format(time.DateOnly),
+		"to":          to.AddDate(0, 0, -1).Format(time.DateOnly),
+		"adherence":   adherence(p, events, from, to),
+	})
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// LLMProvider 大模型服务的统一抽象, 各厂商的请求和响应格式由适配器处理
+type LLMProvider interface {
+	Name() string
+	Chat(ctx context.Context, req chatRequest) (string, error)
+}
+
+type chatMessage struct {
+	Role    string `json:"role"` // user 或 assistant
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	System   string
+	Messages []chatMessage
+	// Schema 非空时要求模型按 json schema 输出, 各适配器使用厂商支持的结构化输出方式
+	Schema map[string]any
+	// OnDelta 非空时使用流式接口, 每收到一段回答调用一次, 返回值仍为完整回答
+	OnDelta func(delta string)
+}
+
+// messages 转换为 OpenAI 风格的消息列表, system 作为第一条消息
+func (r chatRequest) messages() []chatMessage {
+	return append([]chatMessage{{Role: "system", Content: r.System}}, r.Messages...)
+}
+
+// providerConfig 单个大模型服务的配置, 可以写在 -llm-config 指定的 json 文件中
+type providerConfig struct {
+	Name string `json:"name"`
+	// Type 为 openai, gemini, gemini-native, glm, ollama, llamacpp, anthropic;
+	// gemini 与之前的版本一致, 使用 Gemini 的 OpenAI 兼容接口, gemini-native 使用原生 generateContent 接口
+	Type    string   `json:"type"`
+	URL     string   `json:"url"`
+	Model   string   `json:"model"`
+	APIKey  string   `json:"apikey"`
+	Timeout duration `json:"timeout"`
+
+	// 重试和熔断, 未配置时使用 newGuardedProvider 中的默认值
+	Retries          *int     `json:"retries,omitempty"`
+	Backoff          duration `json:"backoff,omitempty"`
+	BreakerThreshold int      `json:"breaker_threshold,omitempty"`
+	BreakerCooldown  duration `json:"breaker_cooldown,omitempty"`
+}
+
+// duration 支持在 json 中使用 "30s" 形式的时长
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var n float64
+		if err := json.Unmarshal(b, &n); err != nil {
+			return fmt.Errorf("invalid duration: %s", string(b))
+		}
+		*d = duration(time.Duration(n * float64(time.Second)))
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+var providerDefaults = map[string]providerConfig{
+	"openai":        {URL: "https://api.openai.com/v1/chat/completions", Model: "gpt-4o-mini"},
+	"gemini":        {URL: "https://generativelanguage.googleapis.com/v1beta/openai/chat/completions", Model: "gemini-2.0-flash-lite"},
+	"gemini-native": {URL: "https://generativelanguage.googleapis.com/v1beta", Model: "gemini-2.0-flash-lite"},
+	"glm":           {URL: "https://open.bigmodel.cn/api/paas/v4/chat/completions", Model: "glm-4-flash"},
+	"ollama":        {URL: "http://127.0.0.1:11434/api/chat", Model: "qwen2.5"},
+	"llamacpp":      {URL: "http://127.0.0.1:8080/v1/chat/completions", Model: "default"},
+	"anthropic":     {URL: "https://api.anthropic.com/v1/messages", Model: "claude-3-5-haiku-latest"},
+}
+
+var llm LLMProvider
+
+func newProvider(cfg providerConfig) (LLMProvider, error) {
+	kind := strings.ToLower(strings.TrimSpace(cfg.Type))
+	if kind == "" {
+		kind = "glm"
+	}
+
+	def, ok := providerDefaults[kind]
+	if !ok {
+		return nil, fmt.Errorf("unsupported llm type: %s", cfg.Type)
+	}
+	if cfg.URL == "" {
+		cfg.URL = def.URL
+	}
+	if cfg.Model == "" {
+		cfg.Model = def.Model
+	}
+	if cfg.Name == "" {
+		cfg.Name = kind
+	}
+	cfg.Type = kind
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = duration(10 * time.Second)
+	}
+
+	// 不设置 Client.Timeout, 它会截断耗时较长的流式回答; timeout 限制等待响应头的时间,
+	// 流式请求另外限制两段数据之间的间隔, 见 postStream
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: time.Duration(cfg.Timeout),
+		},
+	}
+
+	switch kind {
+	case "gemini-native":
+		// 原生接口的 url 为 api 根路径, OpenAI 兼容接口的地址说明配置的类型有误
+		if strings.Contains(cfg.URL, "/chat/completions") {
+			return nil, fmt.Errorf("llm %s: %s is an openai-compatible url, use type gemini instead of gemini-native", cfg.Name, cfg.URL)
+		}
+		return &geminiProvider{cfg: cfg, client: client}, nil
+	case "ollama":
+		return &ollamaProvider{cfg: cfg, client: client}, nil
+	case "anthropic":
+		return &anthropicProvider{cfg: cfg, client: client}, nil
+	default:
+		// glm, gemini 和 llama.cpp server 均兼容 OpenAI chat completions 接口
+		return &openaiProvider{cfg: cfg, client: client}, nil
+	}
+}
+
+// loadProviderConfigs 读取 json 配置文件, 内容为 providerConfig 数组
+func loadProviderConfigs(path string) ([]providerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read llm config: %w", err)
+	}
+
+	var cfgs []providerConfig
+	if err := json.Unmarshal(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal llm config: %w", err)
+	}
+
+	for i := range cfgs {
+		cfgs[i].APIKey = os.ExpandEnv(cfgs[i].APIKey)
+	}
+
+	return cfgs, nil
+}
+
+// sendJSON 发送 json 请求, 非 200 状态码作为错误返回, 调用方负责关闭响应体
+func sendJSON(ctx context.Context, client *http.Client, urlstr string, headers map[string]string, body any) (*http.Response, error) {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, urlstr, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, &httpStatusError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			Body:       string(respBody),
+		}
+	}
+
+	return resp, nil
+}
+
+// postJSON 发送 json 请求并解析 json 响应
+func postJSON(ctx context.Context, client *http.Client, urlstr string, headers map[string]string, body, out any) error {
+	resp, err := sendJSON(ctx, client, urlstr, headers, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to unmarshal response body: %w, body: %s", err, string(respBody))
+	}
+
+	return nil
+}
+
+// postStream 发送流式请求, 逐条解析响应中的 json 数据 (SSE 的 data 行或 NDJSON 的每一行) 并回调 onData.
+// 超过 idle 没有收到新数据时中断请求
+func postStream(ctx context.Context, client *http.Client, idle time.Duration, urlstr string, headers map[string]string, body any, onData func(data []byte) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var stalled atomic.Bool
+	watchdog := time.AfterFunc(idle, func() {
+		stalled.Store(true)
+		cancel()
+	})
+	defer watchdog.Stop()
+
+	resp, err := sendJSON(ctx, client, urlstr, headers, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		watchdog.Reset(idle)
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || bytes.HasPrefix(line, []byte("event:")) || bytes.HasPrefix(line, []byte(":")) {
+			continue
+		}
+		line = bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data:")))
+		if string(line) == "[DONE]" {
+			break
+		}
+		if err := onData(line); err != nil {
+			return fmt.Errorf("failed to unmarshal stream data: %w, data: %s", err, string(line))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if stalled.Load() {
+			return fmt.Errorf("failed to read response stream: no data for %s", idle)
+		}
+		return fmt.Errorf("failed to read response stream: %w", err)
+	}
+
+	return nil
+}
+
+// openaiProvider OpenAI chat completions 及其兼容接口 (glm, llama.cpp 等)
+type openaiProvider struct {
+	cfg    providerConfig
+	client *http.Client
+}
+
+func (p *openaiProvider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *openaiProvider) Chat(ctx context.Context, req chatRequest) (string, error) {
+	body := map[string]any{
+		"model":    p.cfg.Model,
+		"messages": req.messages(),
+	}
+
+	if req.Schema != nil {
+		if p.cfg.Type == "glm" {
+			// glm 仅支持 json_object 模式, schema 由提示词和校验保证
+			body["response_format"] = map[string]any{"type": "json_object"}
+		} else {
+			schema := any(req.Schema)
+			if p.cfg.Type == "gemini" {
+				schema = stripSchema(req.Schema)
+			}
+			body["response_format"] = map[string]any{
+				"type": "json_schema",
+				"json_schema": map[string]any{
+					"name":   "plants",
+					"schema": schema,
+				},
+			}
+		}
+	}
+
+	headers := map[string]string{}
+	if p.cfg.APIKey != "" {
+		headers["Authorization"] = "Bearer " + p.cfg.APIKey
+	}
+
+	if req.OnDelta != nil {
+		body["stream"] = true
+
+		var sb strings.Builder
+		err := postStream(ctx, p.client, time.Duration(p.cfg.Timeout), p.cfg.URL, headers, body, func(data []byte) error {
+			var chunk struct {
+				Choices []struct {
+					Delta struct {
+						Content string `json:"content"`
+					} `json:"delta"`
+				} `json:"choices"`
+			}
+			if err := json.Unmarshal(data, &chunk); err != nil {
+				return err
+			}
+			for _, c := range chunk.Choices {
+				if c.Delta.Content != "" {
+					sb.WriteString(c.Delta.Content)
+					req.OnDelta(c.Delta.Content)
+				}
+			}
+			return nil
+		})
+
+		return sb.String(), err
+	}
+
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := postJSON(ctx, p.client, p.cfg.URL, headers, body, &resp); err != nil {
+		return "", err
+	}
+
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("no choices found in response")
+	}
+
+	return resp.Choices[0].Message.Content, nil
+}
+
+// geminiProvider Gemini 原生 generateContent 接口, url 为 api 根路径
+type geminiProvider struct {
+	cfg    providerConfig
+	client *http.Client
+}
+
+func (p *geminiProvider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *geminiProvider) Chat(ctx context.Context, req chatRequest) (string, error) {
+	var contents []map[string]any
+	for _, m := range req.Messages {
+		role := m.Role
+		if role == "assistant" {
+			role = "model"
+		}
+		contents = append(contents, map[string]any{"role": role, "parts": []map[string]string{{"text": m.Content}}})
+	}
+
+	body := map[string]any{
+		"systemInstruction": map[string]any{
+			"parts": []map[string]string{{"text": req.System}},
+		},
+		"contents": contents,
+	}
+
+	if req.Schema != nil {
+		body["generationConfig"] = map[string]any{
+			"responseMimeType": "application/json",
+			"responseSchema":   stripSchema(req.Schema),
+		}
+	}
+
+	base := fmt.Sprintf("%s/models/%s", strings.TrimSuffix(p.cfg.URL, "/"), url.PathEscape(p.cfg.Model))
+	headers := map[string]string{"x-goog-api-key": p.cfg.APIKey}
+
+	type geminiResponse struct {
+		Candidates []struct {
+			Content struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"content"`
+		} `json:"candidates"`
+	}
+
+	var sb strings.Builder
+	if req.OnDelta != nil {
+		err := postStream(ctx, p.client, time.Duration(p.cfg.Timeout), base+":streamGenerateContent?alt=sse", headers, body, func(data []byte) error {
+			var chunk geminiResponse
+			if err := json.Unmarshal(data, &chunk); err != nil {
+				return err
+			}
+			if len(chunk.Candidates) > 0 {
+				for _, part := range chunk.Candidates[0].Content.Parts {
+					sb.WriteString(part.Text)
+					req.OnDelta(part.Text)
+				}
+			}
+			return nil
+		})
+
+		return sb.String(), err
+	}
+
+	var resp geminiResponse
+	if err := postJSON(ctx, p.client, base+":generateContent", headers, body, &resp); err != nil {
+		return "", err
+	}
+
+	if len(resp.Candidates) == 0 {
+		return "", fmt.Errorf("no candidates found in response")
+	}
+
+	for _, part := range resp.Candidates[0].Content.Parts {
+		sb.WriteString(part.Text)
+	}
+
+	return sb.String(), nil
+}
+
+// ollamaProvider 本地 Ollama /api/chat 接口
+type ollamaProvider struct {
+	cfg    providerConfig
+	client *http.Client
+}
+
+func (p *ollamaProvider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *ollamaProvider) Chat(ctx context.Context, req chatRequest) (string, error) {
+	body := map[string]any{
+		"model":    p.cfg.Model,
+		"stream":   req.OnDelta != nil,
+		"messages": req.messages(),
+	}
+
+	if req.Schema != nil {
+		body["format"] = req.Schema
+	}
+
+	type ollamaResponse struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	}
+
+	if req.OnDelta != nil {
+		// 流式响应为 NDJSON, 每行一段回答
+		var sb strings.Builder
+		err := postStream(ctx, p.client, time.Duration(p.cfg.Timeout), p.cfg.URL, nil, body, func(data []byte) error {
+			var chunk ollamaResponse
+			if err := json.Unmarshal(data, &chunk); err != nil {
+				return err
+			}
+			if chunk.Message.Content != "" {
+				sb.WriteString(chunk.Message.Content)
+				req.OnDelta(chunk.Message.Content)
+			}
+			return nil
+		})
+
+		return sb.String(), err
+	}
+
+	var resp ollamaResponse
+	if err := postJSON(ctx, p.client, p.cfg.URL, nil, body, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.Message.Content, nil
+}
+
+// anthropicProvider Anthropic Messages 接口, 结构化输出通过强制调用工具实现
+type anthropicProvider struct {
+	cfg    providerConfig
+	client *http.Client
+}
+
+func (p *anthropicProvider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *anthropicProvider) Chat(ctx context.Context, req chatRequest) (string, error) {
+	body := map[string]any{
+		"model":      p.cfg.Model,
+		"max_tokens": 4096,
+		"system":     req.System,
+		"messages":   req.Messages,
+	}
+
+	if req.Schema != nil {
+		body["tools"] = []map[string]any{{
+			"name":         "record_plants",
+			"description":  "记录植物信息",
+			"input_schema": req.Schema,
+		}}
+		body["tool_choice"] = map[string]any{"type": "tool", "name": "record_plants"}
+	}
+
+	headers := map[string]string{
+		"x-api-key":         p.cfg.APIKey,
+		"anthropic-version": "2023-06-01",
+	}
+
+	if req.OnDelta != nil {
+		body["stream"] = true
+
+		// 工具调用的参数以 input_json_delta 分段返回, 拼接后即为完整 json
+		var text, input strings.Builder
+		err := postStream(ctx, p.client, time.Duration(p.cfg.Timeout), p.cfg.URL, headers, body, func(data []byte) error {
+			var event struct {
+				Type  string `json:"type"`
+				Delta struct {
+					Type        string `json:"type"`
+					Text        string `json:"text"`
+					PartialJSON string `json:"partial_json"`
+				} `json:"delta"`
+			}
+			if err := json.Unmarshal(data, &event); err != nil {
+				return err
+			}
+			if event.Type != "content_block_delta" {
+				return nil
+			}
+			switch event.Delta.Type {
+			case "input_json_delta":
+				input.WriteString(event.Delta.PartialJSON)
+				req.OnDelta(event.Delta.PartialJSON)
+			case "text_delta":
+				text.WriteString(event.Delta.Text)
+				req.OnDelta(event.Delta.Text)
+			}
+			return nil
+		})
+		if input.Len() > 0 {
+			return input.String(), err
+		}
+
+		return text.String(), err
+	}
+
+	var resp struct {
+		Content []struct {
+			Type  string          `json:"type"`
+			Text  string          `json:"text"`
+			Input json.RawMessage `json:"input"`
+		} `json:"content"`
+	}
+	if err := postJSON(ctx, p.client, p.cfg.URL, headers, body, &resp); err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, c := range resp.Content {
+		switch c.Type {
+		case "tool_use":
+			return string(c.Input), nil
+		case "text":
+			sb.WriteString(c.Text)
+		}
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io/fs"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// maxImageSize 单张镜像图片的最大字节数
+const maxImageSize = 20 << 20
+
+// maxImagePixels 解码图片的最大像素数, 压缩率很高的小文件也可能解码出数 GB 的位图
+const maxImagePixels = 50_000_000
+
+// mediaMeta 镜像图片的元数据, 与各尺寸图片存放在同一目录, 最后写入, 存在即表示图片已完整保存
+type mediaMeta struct {
+	Hash      string    `json:"hash"`
+	Source    string    `json:"source,omitempty"`
+	Format    string    `json:"format"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	PHash     string    `json:"phash,omitempty"`  // 感知哈希
+	Stddev    float64   `json:"stddev,omitempty"` // 灰度标准差
+	Sizes     []int     `json:"sizes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// mediaStore 以内容 sha256 寻址的本地图片库, 每张图片保存原图和若干宽度的 jpeg 缩略图:
+// <dir>/<hash[:2]>/<hash>/orig.<ext>, <size>.jpg, meta.json
+type mediaStore struct {
+	dir     string
+	sizes   []int // 缩略图宽度, 升序
+	display int   // 植物 json 中引用的尺寸
+	client  *http.Client
+
+	mu       sync.Mutex
+	bySource map[string]string // 原始地址到 hash, 避免重复下载
+}
+
+var media *mediaStore
+
+// parseSizes 解析 "160,480,1024" 形式的缩略图宽度列表
+func parseSizes(s string) ([]int, error) {
+	var sizes []int
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid thumbnail size: %s", f)
+		}
+		sizes = append(sizes, n)
+	}
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("no thumbnail size configured")
+	}
+
+	slices.Sort(sizes)
+	return slices.Compact(sizes), nil
+}
+
+// openMediaStore 打开图片库, 植物 json 引用居中的尺寸 (默认 480)
+func openMediaStore(dir string, sizes []int) (*mediaStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create media dir: %w", err)
+	}
+
+	m := &mediaStore{
+		dir:      dir,
+		sizes:    sizes,
+		display:  sizes[len(sizes)/2],
+		client:   publicClient(30 * time.Second),
+		bySource: map[string]string{},
+	}
+
+	// 从元数据恢复原始地址索引
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() != "meta.json" {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var meta mediaMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			log.Printf("failed to unmarshal media meta %s: %v\n", path, err)
+			return nil
+		}
+		if meta.Source != "" {
+			m.bySource[meta.Source] = meta.Hash
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan media dir: %w", err)
+	}
+
+	return m, nil
+}
+
+func (m *mediaStore) path(hash string) string {
+	return filepath.Join(m.dir, hash[:2], hash)
+}
+
+// URL 返回图片指定宽度缩略图的访问路径
+func (m *mediaStore) URL(hash string, size int) string {
+	return fmt.Sprintf("/media/%s/%s/%d.jpg", hash[:2], hash, size)
+}
+
+func (m *mediaStore) meta(hash string) (*mediaMeta, error) {
+	data, err := os.ReadFile(filepath.Join(m.path(hash), "meta.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var meta mediaMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+// decodeImage 先读取图片尺寸, 像素数超过 maxImagePixels 时不解码
+func decodeImage(data []byte) (image.Image, string, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxImagePixels {
+		return nil, "", fmt.Errorf("image too large: %dx%d (at most %d pixels)", cfg.Width, cfg.Height, maxImagePixels)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	return img, format, nil
+}
+
+// Save 保存图片内容并生成缩略图, 相同内容只保存一次; 并发保存同一内容时写入的文件相同, 无需加锁
+func (m *mediaStore) Save(data []byte, source string) (*mediaMeta, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	if meta, err := m.meta(hash); err == nil {
+		return meta, nil
+	}
+
+	img, format, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := m.path(hash)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create media dir: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "orig."+format), data, nil); err != nil {
+		return nil, err
+	}
+
+	for _, size := range m.sizes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, thumbnail(img, size), &jpeg.Options{Quality: 85}); err != nil {
+			return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+		}
+		if err := writeFileAtomic(filepath.Join(dir, fmt.Sprintf("%d.jpg", size)), buf.Bytes(), nil); err != nil {
+			return nil, err
+		}
+	}
+
+	score := scoreImage(img)
+	meta := &mediaMeta{
+		Hash:      hash,
+		Source:    source,
+		Format:    format,
+		Width:     score.Width,
+		Height:    score.Height,
+		PHash:     formatHash(score.PHash),
+		Stddev:    score.Stddev,
+		Sizes:     m.sizes,
+		CreatedAt: time.Now(),
+	}
+	metaData, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "meta.json"), metaData, nil); err != nil {
+		return nil, err
+	}
+
+	if source != "" {
+		m.mu.Lock()
+		m.bySource[source] = hash
+		m.mu.Unlock()
+	}
+
+	return meta, nil
+}
+
+// thumbnail 按宽度等比缩放 (不放大), 透明背景填充为白色
+func thumbnail(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > width {
+		w, h = width, max(b.Dy()*width/b.Dx(), 1)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+
+	return dst
+}
+
+// Mirror 下载远程图片保存到本地, 返回引用尺寸的本地路径
+func (m *mediaStore) Mirror(ctx context.Context, src string) (string, error) {
+	meta, err := m.MirrorMeta(ctx, src)
+	if err != nil {
+		return "", err
+	}
+
+	return m.URL(meta.Hash, m.display), nil
+}
+
+// mirrored 返回已下载过的远程地址的元数据
+func (m *mediaStore) mirrored(src string) (*mediaMeta, bool) {
+	m.mu.Lock()
+	hash, ok := m.bySource[src]
+	m.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	meta, err := m.meta(hash)
+
+	return meta, err == nil
+}
+
+// MirrorMeta 下载远程图片保存到本地, 返回图片元数据, 已下载过的地址不再重复下载
+func (m *mediaStore) MirrorMeta(ctx context.Context, src string) (*mediaMeta, error) {
+	if meta, ok := m.mirrored(src); ok {
+		return meta, nil
+	}
+
+	data, err := downloadImage(ctx, m.client, src)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Save(data, src)
+}
+
+// errPrivateAddress 图片地址指向本机或内网, 拒绝下载, 以免镜像功能被用来访问内网服务 (SSRF)
+var errPrivateAddress = errors.New("refusing to fetch from a loopback, private or link-local address")
+
+// publicAddr 是否为公网地址
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// publicClient 只连接公网地址的 http 客户端. 在 DNS 解析之后的连接阶段检查, 解析到内网的域名和
+// 重定向到内网的地址同样会被拒绝; 不使用代理, 否则检查的是代理的地址
+func publicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip, err := netip.ParseAddr(host)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(ip) {
+				return fmt.Errorf("%w: %s", errPrivateAddress, ip)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second},
+	}
+}
+
+// isRemoteImage 是否为尚未镜像的远程图片
+func isRemoteImage(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+// localizeImages 将植物的封面和候选图片镜像到本地, 原始地址记录在 Origins 中, 镜像失败时保留原地址
+func localizeImages(ctx context.Context, p *Plant) {
+	if media == nil {
+		return
+	}
+
+	replaceImages(p, func(src string) string {
+		local, err := media.Mirror(ctx, src)
+		if err != nil {
+			log.Printf("failed to mirror image %s: %v\n", src, err)
+			return src
+		}
+		return local
+	})
+}
+
+// replaceImages 将封面和候选图片中的远程地址替换为 localize 返回的本地地址, 并维护 Origins
+func replaceImages(p *Plant, localize func(src string) string) {
+	p.Origins = maps.Clone(p.Origins)
+	mirror := func(src string) string {
+		if !isRemoteImage(src) {
+			return src
+		}
+		local := localize(src)
+		if local == src {
+			return src
+		}
+		if p.Origins == nil {
+			p.Origins = map[string]string{}
+		}
+		if _, ok := p.Origins[local]; !ok {
+			p.Origins[local] = src
+		}
+		return local
+	}
+
+	p.Image = mirror(p.Image)
+	images := make([]string, 0, len(p.Images))
+	for _, src := range p.Images {
+		if src = mirror(src); !slices.Contains(images, src) {
+			images = append(images, src)
+		}
+	}
+	p.Images = images
+
+	// 只保留仍被引用的图片来源
+	for local := range p.Origins {
+		if local != p.Image && !slices.Contains(p.Images, local) {
+			delete(p.Origins, local)
+		}
+	}
+	if len(p.Origins) == 0 {
+		p.Origins = nil
+	}
+}
+
+// errUnchanged Modify 中没有需要写回的修改
+var errUnchanged = errors.New("unchanged")
+
+// mirrorCatalog 将目录中已有植物的远程图片镜像到本地
+func mirrorCatalog(ctx context.Context) {
+	plants, err := store.List()
+	if err != nil {
+		log.Println("failed to list plants for mirroring:", err)
+		return
+	}
+
+	n := 0
+	for _, p := range plants {
+		if ctx.Err() != nil {
+			return
+		}
+		if !isRemoteImage(p.Image) && !slices.ContainsFunc(p.Images, isRemoteImage) {
+			continue
+		}
+
+		// 下载耗时较长, 在修改之外进行; 写回时重新读取植物, 只替换已镜像的图片地址, 不覆盖期间的其他修改
+		mirrored := map[string]string{}
+		for _, src := range append([]string{p.Image}, p.Images...) {
+			if !isRemoteImage(src) || mirrored[src] != "" {
+				continue
+			}
+			local, err := media.Mirror(ctx, src)
+			if err != nil {
+				log.Printf("failed to mirror image %s: %v\n", src, err)
+				continue
+			}
+			mirrored[src] = local
+		}
+		if len(mirrored) == 0 {
+			continue
+		}
+
+		_, err := store.Modify(p.ID, func(plant *Plant) error {
+			image, images := plant.Image, slices.Clone(plant.Images)
+			replaceImages(plant, func(src string) string { return cmp.Or(mirrored[src], src) })
+			if plant.Image == image && slices.Equal(plant.Images, images) {
+				return errUnchanged
+			}
+			return nil
+		})
+		if errors.Is(err, errUnchanged) {
+			continue
+		}
+		if err != nil {
+			log.Printf("failed to update mirrored images of %s: %v\n", p.Cnname, err)
+			continue
+		}
+		n++
+	}
+
+	log.Printf("mirrored images of %d plants\n", n)
+}
+
+// mediaHandler 提供 /media/ 下的图片, 内容寻址的文件不会变化, 可长期缓存
+func mediaHandler(dir string) http.Handler {
+	fileServer := http.StripPrefix("/media/", http.FileServer(http.Dir(dir)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		fileServer.ServeHTTP(w, r)
+	})
+}
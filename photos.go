@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Photo 用户上传的植物照片, Path 为 /media/ 下的本地路径
+type Photo struct {
+	Path       string     `json:"path"`
+	TakenAt    *time.Time `json:"taken_at,omitempty"` // EXIF 中的拍摄时间
+	UploadedAt time.Time  `json:"uploaded_at"`
+	Width      int        `json:"width"`
+	Height     int        `json:"height"`
+	PHash      string     `json:"phash,omitempty"` // 感知哈希, 用于去重
+}
+
+// allowedPhotoTypes 允许上传的图片类型, 以文件内容嗅探的结果为准
+var allowedPhotoTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+// unsupportedMediaError 上传的文件不是支持的图片类型
+type unsupportedMediaError struct {
+	Filename string
+	Type     string
+}
+
+func (e *unsupportedMediaError) Error() string {
+	return fmt.Sprintf("unsupported media type %s of %s", e.Type, e.Filename)
+}
+
+// exifInfo 从 EXIF 中读取的方向和拍摄时间
+type exifInfo struct {
+	Orientation int
+	TakenAt     *time.Time
+}
+
+// SaveUpload 去掉照片的 EXIF 等元数据后保存, 需要旋转的照片按 EXIF 方向转正后重新编码, 未通过质量过滤的照片不保存
+func (m *mediaStore) SaveUpload(data []byte, filename string, captureDate bool) (*Photo, error) {
+	mime := http.DetectContentType(data)
+	if !slices.Contains(allowedPhotoTypes, mime) {
+		return nil, &unsupportedMediaError{Filename: filename, Type: mime}
+	}
+
+	var info exifInfo
+	switch mime {
+	case "image/jpeg":
+		info = parseJPEGExif(data)
+		data = stripJPEGMetadata(data)
+	case "image/png":
+		data = stripPNGMetadata(data)
+	case "image/webp":
+		data = stripWebPMetadata(data)
+	}
+
+	img, _, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	if info.Orientation > 1 && info.Orientation <= 8 {
+		img = orient(img, info.Orientation)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 92}); err != nil {
+			return nil, fmt.Errorf("failed to encode image: %w", err)
+		}
+		data = buf.Bytes()
+	}
+	if err := imageFilter.Check(scoreImage(img)); err != nil {
+		return nil, err
+	}
+
+	meta, err := m.Save(data, "")
+	if err != nil {
+		return nil, err
+	}
+
+	photo := &Photo{
+		Path:       m.URL(meta.Hash, m.display),
+		UploadedAt: time.Now(),
+		Width:      meta.Width,
+		Height:     meta.Height,
+		PHash:      meta.PHash,
+	}
+	if captureDate {
+		photo.TakenAt = info.TakenAt
+	}
+
+	return photo, nil
+}
+
+// parseJPEGExif 读取 JPEG APP1 段中的方向和拍摄时间, 解析失败时返回零值
+func parseJPEGExif(data []byte) exifInfo {
+	var info exifInfo
+	eachJPEGSegment(data, func(marker byte, seg []byte) {
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			info = parseTIFF(seg[6:])
+		}
+	})
+
+	return info
+}
+
+// parseTIFF 解析 EXIF 的 TIFF 结构: IFD0 中的 Orientation 和 DateTime, Exif IFD 中的 DateTimeOriginal
+func parseTIFF(t []byte) exifInfo {
+	var info exifInfo
+	if len(t) < 8 {
+		return info
+	}
+
+	var bo binary.ByteOrder
+	switch string(t[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return info
+	}
+
+	// readIFD 返回 tag 到 12 字节条目的映射
+	readIFD := func(off uint32) map[uint16][]byte {
+		entries := map[uint16][]byte{}
+		if int(off)+2 > len(t) {
+			return entries
+		}
+		n := int(bo.Uint16(t[off:]))
+		for i := 0; i < n; i++ {
+			p := int(off) + 2 + i*12
+			if p+12 > len(t) {
+				break
+			}
+			entries[bo.Uint16(t[p:])] = t[p : p+12]
+		}
+		return entries
+	}
+	readString := func(e []byte) string {
+		count, off := bo.Uint32(e[4:]), bo.Uint32(e[8:])
+		if count <= 4 || int(off)+int(count) > len(t) {
+			return ""
+		}
+		return string(bytes.TrimRight(t[off:off+count], "\x00 "))
+	}
+	parseTime := func(s string) *time.Time {
+		ts, err := time.ParseInLocation("2006:01:02 15:04:05", s, time.Local)
+		if err != nil {
+			return nil
+		}
+		return &ts
+	}
+
+	ifd0 := readIFD(bo.Uint32(t[4:]))
+	if e, ok := ifd0[0x0112]; ok {
+		info.Orientation = int(bo.Uint16(e[8:]))
+	}
+	if e, ok := ifd0[0x8769]; ok {
+		if e, ok := readIFD(bo.Uint32(e[8:]))[0x9003]; ok {
+			info.TakenAt = parseTime(readString(e))
+		}
+	}
+	if e, ok := ifd0[0x0132]; ok && info.TakenAt == nil {
+		info.TakenAt = parseTime(readString(e))
+	}
+
+	return info
+}
+
+// eachJPEGSegment 遍历 SOS 之前的 JPEG 段, 返回 SOS 段的起始位置
+func eachJPEGSegment(data []byte, fn func(marker byte, seg []byte)) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return -1
+	}
+
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return -1
+		}
+		marker := data[i+1]
+		if marker == 0xFF { // 填充字节
+			i++
+			continue
+		}
+		if marker == 0xDA {
+			return i
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			return -1
+		}
+		if fn != nil {
+			fn(marker, data[i+4:i+2+n])
+		}
+		i += 2 + n
+	}
+
+	return -1
+}
+
+// stripJPEGMetadata 去掉 EXIF/XMP (APP1), IPTC (APP13), 厂商私有段和注释, 保留 JFIF, ICC 和 Adobe 段
+func stripJPEGMetadata(data []byte) []byte {
+	out := []byte{0xFF, 0xD8}
+	sos := eachJPEGSegment(data, func(marker byte, seg []byte) {
+		if marker == 0xFE || (marker >= 0xE1 && marker <= 0xEF && marker != 0xE2 && marker != 0xEE) {
+			return
+		}
+		out = append(out, 0xFF, marker)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(seg)+2))
+		out = append(out, seg...)
+	})
+	if sos < 0 {
+		return data
+	}
+
+	return append(out, data[sos:]...)
+}
+
+// stripPNGMetadata 去掉 eXIf 和文本, 时间块
+func stripPNGMetadata(data []byte) []byte {
+	if len(data) < 8 {
+		return data
+	}
+
+	out := slices.Clone(data[:8])
+	for i := 8; i+12 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + n
+		if end > len(data) {
+			return data
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "iTXt", "zTXt", "tIME":
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+
+	return out
+}
+
+// stripWebPMetadata 去掉 EXIF 和 XMP 块, 并清除 VP8X 中对应的标志位
+func stripWebPMetadata(data []byte) []byte {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return data
+	}
+
+	out := slices.Clone(data[:12])
+	for i := 12; i+8 <= len(data); {
+		n := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + n + n%2
+		if end > len(data) {
+			return data
+		}
+		switch fourcc := string(data[i : i+4]); fourcc {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := slices.Clone(data[i:end])
+			chunk[8] &^= 0x08 | 0x04
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+
+	return out
+}
+
+// orient 按 EXIF 方向 (2-8) 翻转或旋转图片
+func orient(img image.Image, o int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+
+	return dst
+}
+
+// readPhotoUploads 读取并保存表单字段 photos 中的照片, 出错时写入错误响应并返回 false;
+// cover=true 时以第一张照片作为封面, exif_date=false 时不记录拍摄时间
+func readPhotoUploads(w http.ResponseWriter, r *http.Request) ([]Photo, bool, bool) {
+	if media == nil {
+		writeError(w, http.StatusServiceUnavailable, "media_disabled", "media store is disabled", nil)
+		return nil, false, false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			writeError(w, http.StatusRequestEntityTooLarge, "too_large", fmt.Sprintf("request larger than %d bytes", mbe.Limit), nil)
+			return nil, false, false
+		}
+		writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("parsing multipart form error: %v", err), nil)
+		return nil, false, false
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["photos"]
+	if len(files) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "validation_failed", "photos is required", map[string]string{"photos": "is required"})
+		return nil, false, false
+	}
+	captureDate := r.FormValue("exif_date") != "false"
+	cover, _ := strconv.ParseBool(r.FormValue("cover"))
+
+	var photos []Photo
+	for _, fh := range files {
+		if fh.Size > maxImageSize {
+			writeError(w, http.StatusRequestEntityTooLarge, "too_large", fmt.Sprintf("%s larger than %d bytes", fh.Filename, maxImageSize), map[string]string{"file": fh.Filename})
+			return nil, false, false
+		}
+
+		f, err := fh.Open()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("opening %s error: %v", fh.Filename, err), nil)
+			return nil, false, false
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("reading %s error: %v", fh.Filename, err), nil)
+			return nil, false, false
+		}
+
+		photo, err := media.SaveUpload(data, fh.Filename, captureDate)
+		if err != nil {
+			var uerr *unsupportedMediaError
+			if errors.As(err, &uerr) {
+				writeError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", err.Error(), map[string]string{"file": uerr.Filename, "type": uerr.Type})
+				return nil, false, false
+			}
+			if errors.Is(err, errRejectedImage) {
+				writeError(w, http.StatusUnprocessableEntity, "rejected_image", fmt.Sprintf("%s: %v", fh.Filename, err), map[string]string{"file": fh.Filename})
+				return nil, false, false
+			}
+			writeError(w, http.StatusUnprocessableEntity, "invalid_image", fmt.Sprintf("%s: %v", fh.Filename, err), map[string]string{"file": fh.Filename})
+			return nil, false, false
+		}
+		photos = append(photos, *photo)
+	}
+
+	return photos, cover, true
+}
+
+// mergePhotos 将新照片追加到已有照片后, 与已有照片内容相同或感知哈希相近的照片视为重复, 不再添加; 返回合并结果和实际添加的照片
+func mergePhotos(existing, photos []Photo, name string) ([]Photo, []Photo) {
+	var hashes []uint64
+	for _, p := range existing {
+		if h, err := strconv.ParseUint(p.PHash, 16, 64); err == nil {
+			hashes = append(hashes, h)
+		}
+	}
+
+	merged := slices.Clone(existing)
+	var added []Photo
+	for _, photo := range photos {
+		if slices.ContainsFunc(merged, func(p Photo) bool { return p.Path == photo.Path }) {
+			continue
+		}
+		if h, err := strconv.ParseUint(photo.PHash, 16, 64); err == nil {
+			if imageFilter.IsDuplicate(h, hashes) {
+				log.Printf("skipped duplicate photo %s of %s\n", photo.Path, name)
+				continue
+			}
+			hashes = append(hashes, h)
+		}
+		merged = append(merged, photo)
+		added = append(added, photo)
+	}
+
+	return merged, added
+}
+
+// uploadPhotosHandler 为植物上传照片, 表单字段 photos 可包含多个文件;
+// cover=true 时以第一张新照片作为封面, exif_date=false 时不记录拍摄时间
+func uploadPhotosHandler(w http.ResponseWriter, r *http.Request) {
+	plant, err := store.Get(r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	photos, cover, ok := readPhotoUploads(w, r)
+	if !ok {
+		return
+	}
+	// 在 Modify 中合并, 同时上传的照片不会相互覆盖
+	var added []Photo
+	plant, err = store.Modify(plant.ID, func(p *Plant) error {
+		p.Photos, added = mergePhotos(p.Photos, photos, p.Cnname)
+		if len(added) > 0 && (cover || p.Image == "") {
+			p.Image = added[0].Path
+		}
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	log.Printf("uploaded %d photos for plant %s\n", len(added), plant.Cnname)
+
+	writeJSON(w, http.StatusCreated, plant)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+var errRejectedImage = errors.New("image rejected")
+
+// imageFilterConfig 图片质量过滤的阈值, 写在图片来源配置的 filter 中, 为 0 的项使用默认值
+type imageFilterConfig struct {
+	MinWidth    int      `json:"min_width"`    // 最小宽度, 过滤图标和缩略图
+	MinHeight   int      `json:"min_height"`   // 最小高度
+	MinAspect   float64  `json:"min_aspect"`   // 宽高比下限, 过滤竖条
+	MaxAspect   float64  `json:"max_aspect"`   // 宽高比上限, 过滤横幅
+	MinStddev   float64  `json:"min_stddev"`   // 灰度标准差下限, 过滤纯色和空白占位图
+	MaxDistance int      `json:"max_distance"` // 感知哈希的汉明距离不超过此值视为重复图片
+	Blocked     []string `json:"blocked"`      // 已知占位图, 水印图的感知哈希 (16 位十六进制)
+	Disabled    bool     `json:"disabled"`
+}
+
+var defaultImageFilter = imageFilterConfig{
+	MinWidth:    200,
+	MinHeight:   150,
+	MinAspect:   0.4,
+	MaxAspect:   2.5,
+	MinStddev:   12,
+	MaxDistance: 6,
+}
+
+var imageFilter = defaultImageFilter
+
+// withDefaults 补全未配置的阈值, 并检查屏蔽列表中的哈希
+func (f imageFilterConfig) withDefaults() (imageFilterConfig, error) {
+	if f.MinWidth <= 0 {
+		f.MinWidth = defaultImageFilter.MinWidth
+	}
+	if f.MinHeight <= 0 {
+		f.MinHeight = defaultImageFilter.MinHeight
+	}
+	if f.MinAspect <= 0 {
+		f.MinAspect = defaultImageFilter.MinAspect
+	}
+	if f.MaxAspect <= 0 {
+		f.MaxAspect = defaultImageFilter.MaxAspect
+	}
+	if f.MinStddev <= 0 {
+		f.MinStddev = defaultImageFilter.MinStddev
+	}
+	if f.MaxDistance <= 0 {
+		f.MaxDistance = defaultImageFilter.MaxDistance
+	}
+	for _, h := range f.Blocked {
+		if _, err := strconv.ParseUint(h, 16, 64); err != nil {
+			return f, fmt.Errorf("invalid blocked image hash: %s", h)
+		}
+	}
+
+	return f, nil
+}
+
+// imageScore 图片的尺寸, 感知哈希和灰度标准差
+type imageScore struct {
+	Width  int
+	Height int
+	PHash  uint64
+	Stddev float64
+}
+
+// Check 返回图片被过滤的原因, 通过时返回 nil
+func (f *imageFilterConfig) Check(s imageScore) error {
+	if f.Disabled {
+		return nil
+	}
+
+	if s.Width < f.MinWidth || s.Height < f.MinHeight {
+		return fmt.Errorf("%w: too small (%dx%d)", errRejectedImage, s.Width, s.Height)
+	}
+	if aspect := float64(s.Width) / float64(s.Height); aspect < f.MinAspect || aspect > f.MaxAspect {
+		return fmt.Errorf("%w: aspect ratio %.2f out of range", errRejectedImage, aspect)
+	}
+	if s.Stddev < f.MinStddev {
+		return fmt.Errorf("%w: low detail (stddev %.1f)", errRejectedImage, s.Stddev)
+	}
+	for _, h := range f.Blocked {
+		if b, err := strconv.ParseUint(h, 16, 64); err == nil && hammingDistance(b, s.PHash) <= f.MaxDistance {
+			return fmt.Errorf("%w: matches blocked image %s", errRejectedImage, h)
+		}
+	}
+
+	return nil
+}
+
+// IsDuplicate 图片是否与已有图片之一的感知哈希相近
+func (f *imageFilterConfig) IsDuplicate(hash uint64, hashes []uint64) bool {
+	if f.Disabled {
+		return false
+	}
+
+	return slices.ContainsFunc(hashes, func(h uint64) bool {
+		return hammingDistance(h, hash) <= f.MaxDistance
+	})
+}
+
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func formatHash(h uint64) string {
+	return fmt.Sprintf("%016x", h)
+}
+
+// scoreImage 计算图片的感知哈希 (32x32 灰度图 DCT 低频 8x8 系数与中位数比较) 和灰度标准差
+func scoreImage(img image.Image) imageScore {
+	const n, k = 32, 8
+
+	b := img.Bounds()
+	small := image.NewRGBA(image.Rect(0, 0, n, n))
+	draw.Draw(small, small.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(small, small.Bounds(), img, b, draw.Over, nil)
+
+	var gray [n][n]float64
+	var sum, sumSq float64
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			c := small.RGBAAt(x, y)
+			v := 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+			gray[y][x] = v
+			sum += v
+			sumSq += v * v
+		}
+	}
+	mean := sum / (n * n)
+
+	var cos [k][n]float64
+	for u := 0; u < k; u++ {
+		for x := 0; x < n; x++ {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * n))
+		}
+	}
+
+	coeffs := make([]float64, 0, k*k)
+	for v := 0; v < k; v++ {
+		for u := 0; u < k; u++ {
+			var c float64
+			for y := 0; y < n; y++ {
+				for x := 0; x < n; x++ {
+					c += gray[y][x] * cos[u][x] * cos[v][y]
+				}
+			}
+			coeffs = append(coeffs, c)
+		}
+	}
+
+	// 直流分量与整体亮度相关, 不参与中位数计算
+	sorted := slices.Clone(coeffs[1:])
+	slices.Sort(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, c := range coeffs {
+		if c > median {
+			hash |= 1 << uint(i)
+		}
+	}
+
+	return imageScore{
+		Width:  b.Dx(),
+		Height: b.Dy(),
+		PHash:  hash,
+		Stddev: math.Sqrt(max(sumSq/(n*n)-mean*mean, 0)),
+	}
+}
+
+// downloadImage 下载图片内容, 超过 maxImageSize 时报错
+func downloadImage(ctx context.Context, client *http.Client, src string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	// 部分图床校验 Referer 防盗链
+	if u, err := url.Parse(src); err == nil {
+		req.Header.Set("Referer", u.Scheme+"://"+u.Host+"/")
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download image: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	}
+	if len(data) > maxImageSize {
+		return nil, fmt.Errorf("image larger than %d bytes", maxImageSize)
+	}
+
+	return data, nil
+}
+
+var inspectClient = publicClient(30 * time.Second)
+
+// inspectImage 下载远程图片并在内存中评分, 返回下载的内容; 图片库中已有的图片直接使用保存的评分, 内容为 nil
+func inspectImage(ctx context.Context, src string) (imageScore, []byte, error) {
+	if media != nil {
+		if meta, ok := media.mirrored(src); ok {
+			score, err := media.score(meta)
+			return score, nil, err
+		}
+	}
+
+	data, err := downloadImage(ctx, inspectClient, src)
+	if err != nil {
+		return imageScore{}, nil, err
+	}
+	img, _, err := decodeImage(data)
+	if err != nil {
+		return imageScore{}, nil, err
+	}
+
+	return scoreImage(img), data, nil
+}
+
+// score 返回图片库中图片的评分, 旧版本保存的元数据没有感知哈希时从原图计算
+func (m *mediaStore) score(meta *mediaMeta) (imageScore, error) {
+	if meta.PHash != "" {
+		hash, err := strconv.ParseUint(meta.PHash, 16, 64)
+		if err == nil {
+			return imageScore{Width: meta.Width, Height: meta.Height, PHash: hash, Stddev: meta.Stddev}, nil
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(m.path(meta.Hash), "orig."+meta.Format))
+	if err != nil {
+		return imageScore{}, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return imageScore{}, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	return scoreImage(img), nil
+}
+
+// filterImages 并发评分候选图片, 按原顺序丢弃低质量图片和与 hashes 中已有图片重复的图片,
+// 返回保留的图片, 其下载内容 (未下载时为 nil) 及哈希
+func filterImages(ctx context.Context, plant *Plant, candidates []string, hashes []uint64) ([]string, [][]byte, []uint64) {
+	if imageFilter.Disabled {
+		return candidates, make([][]byte, len(candidates)), hashes
+	}
+
+	scores := make([]imageScore, len(candidates))
+	contents := make([][]byte, len(candidates))
+	errs := make([]error, len(candidates))
+	sem := make(chan struct{}, 4)
+	var wg sync.WaitGroup
+	for i, src := range candidates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			scores[i], contents[i], errs[i] = inspectImage(ctx, src)
+		}()
+	}
+	wg.Wait()
+
+	var images []string
+	var data [][]byte
+	for i, src := range candidates {
+		err := errs[i]
+		if err == nil {
+			err = imageFilter.Check(scores[i])
+		}
+		if err == nil && imageFilter.IsDuplicate(scores[i].PHash, hashes) {
+			err = fmt.Errorf("%w: near duplicate", errRejectedImage)
+		}
+		if err != nil {
+			log.Printf("dropped %s plant image %s: %v\n", plant.Cnname, src, err)
+			continue
+		}
+		images = append(images, src)
+		data = append(data, contents[i])
+		hashes = append(hashes, scores[i].PHash)
+	}
+
+	return images, data, hashes
+}
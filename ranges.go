@@ -0,0 +1,410 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// valueRange 数值范围, 只给出一端 (如 "可达2米", "耐寒-10℃") 时另一端为空
+type valueRange struct {
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// lo 范围的下限, 没有下限时取上限
+func (r *valueRange) lo() (float64, bool) {
+	switch {
+	case r == nil:
+		return 0, false
+	case r.Min != nil:
+		return *r.Min, true
+	case r.Max != nil:
+		return *r.Max, true
+	}
+	return 0, false
+}
+
+// hi 范围的上限, 没有上限时取下限
+func (r *valueRange) hi() (float64, bool) {
+	switch {
+	case r == nil:
+		return 0, false
+	case r.Max != nil:
+		return *r.Max, true
+	case r.Min != nil:
+		return *r.Min, true
+	}
+	return 0, false
+}
+
+func (r *valueRange) String() string {
+	if r == nil {
+		return ""
+	}
+	format := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	}
+	return format(r.Min) + "~" + format(r.Max)
+}
+
+// 解析前统一的范围分隔符, 负号只在数字前出现, 因此 "-25-35" 中第一个 "-" 为负号
+var rangeSeparators = strings.NewReplacer("～", "-", "~", "-", "—", "-", "–", "-", "－", "-", "至", "-", "到", "-", "零下", "-")
+
+// rangeClauses 按标点切分的子句, 限定词只在子句内生效
+var rangeClauses = regexp.MustCompile(`[，,；;。\n]`)
+
+// rangeQualifier 单个数值前后的限定词, 决定它是上限还是下限
+type rangeQualifier struct {
+	max []string
+	min []string
+}
+
+var (
+	sizeQualifier = rangeQualifier{
+		max: []string{"可达", "以下", "以内", "之内", "不超过", "最高", "最大"},
+		min: []string{"以上", "至少", "超过"},
+	}
+	tempQualifier = rangeQualifier{
+		max: []string{"不高于", "不超过", "以下", "最高", "耐热", "高温"},
+		min: []string{"不低于", "以上", "最低", "耐寒", "低温", "越冬", "至少"},
+	}
+)
+
+// bound 单个数值是上限 (1), 下限 (-1) 还是两者 (0); 先判断上限, "不超过" 中含有 "超过"
+func (q rangeQualifier) bound(before, after string) int {
+	context := before + "|" + after
+	switch {
+	case containsAny(context, q.max):
+		return 1
+	case containsAny(context, q.min):
+		return -1
+	}
+	return 0
+}
+
+// rangeMatch 文本中的一个数值或数值范围, 已换算为统一单位
+type rangeMatch struct {
+	from, to float64
+	single   bool
+	before   string // 同一子句中数值之前的文本
+	after    string // 数值之后的几个字
+}
+
+// findRanges 在每个子句中查找 pattern, pattern 的分组依次为: 起始值, 起始单位, 结束值, 结束单位; 两个单位都缺失的数值忽略
+func findRanges(s string, pattern *regexp.Regexp, convert func(v float64, unit string) float64) []rangeMatch {
+	var res []rangeMatch
+	for _, clause := range rangeClauses.Split(rangeSeparators.Replace(strings.ToLower(s)), -1) {
+		prev := 0
+		for _, loc := range pattern.FindAllStringSubmatchIndex(clause, -1) {
+			group := func(i int) string {
+				if loc[2*i] < 0 {
+					return ""
+				}
+				return clause[loc[2*i]:loc[2*i+1]]
+			}
+			fromUnit, toUnit := group(2), group(4)
+			if fromUnit == "" && toUnit == "" {
+				continue
+			}
+			if fromUnit == "" {
+				fromUnit = toUnit
+			}
+			if toUnit == "" {
+				toUnit = fromUnit
+			}
+
+			m := rangeMatch{before: clause[prev:loc[0]], after: clause[loc[1]:]}
+			if r := []rune(m.after); len(r) > 3 {
+				m.after = string(r[:3])
+			}
+			from, _ := strconv.ParseFloat(group(1), 64)
+			m.from = convert(from, fromUnit)
+			if group(3) == "" {
+				m.to, m.single = m.from, true
+			} else {
+				to, _ := strconv.ParseFloat(group(3), 64)
+				m.to = convert(to, toUnit)
+			}
+			if m.from > m.to {
+				m.from, m.to = m.to, m.from
+			}
+			res = append(res, m)
+			prev = loc[1]
+		}
+	}
+
+	return res
+}
+
+var sizePattern = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*(厘米|公分|毫米|英尺|cm|mm|ft|米|m)?(?:\s*-\s*(\d+(?:\.\d+)?)\s*(厘米|公分|毫米|英尺|cm|mm|ft|米|m)?)?`)
+
+// parseSize 解析株高等尺寸为厘米, 只取第一个带单位的数值或范围 (通常为株高), 如 "15-30cm", "1-2米", "可达3m"
+func parseSize(s string) (*valueRange, bool) {
+	ms := findRanges(s, sizePattern, func(v float64, unit string) float64 {
+		switch unit {
+		case "米", "m":
+			return v * 100
+		case "毫米", "mm":
+			return v / 10
+		case "英尺", "ft":
+			return v * 30.48
+		}
+		return v
+	})
+	if len(ms) == 0 {
+		return nil, false
+	}
+
+	return boundedRange(ms[0], sizeQualifier), true
+}
+
+var tempPattern = regexp.MustCompile(`(-?\d+(?:\.\d+)?)\s*(°c|℃|°f|℉|°|度)?(?:\s*-\s*(-?\d+(?:\.\d+)?)\s*(°c|℃|°f|℉|°|度)?)?`)
+
+// parseTemperature 解析温度为摄氏度, 如 "-25-35°C", "生长适温18-28℃, 越冬不低于5℃".
+// 适温 (growing) 取第一个范围或不带限定词的数值; 带 "越冬", "耐寒", "耐热" 等限定词的单个数值为耐受极限 (limits),
+// "生长适温18-28℃, 越冬不低于5℃" 的适温为 18~28, 极限为 5~. 没有范围时适温取各极限
+func parseTemperature(s string) (growing, limits *valueRange, ok bool) {
+	ms := findRanges(s, tempPattern, func(v float64, unit string) float64 {
+		if unit == "°f" || unit == "℉" {
+			return math.Round((v-32)*5/9*10) / 10
+		}
+		return v
+	})
+	if len(ms) == 0 {
+		return nil, nil, false
+	}
+
+	for _, m := range ms {
+		if m.single && tempQualifier.bound(m.before, m.after) != 0 {
+			r := boundedRange(m, tempQualifier)
+			if limits == nil {
+				limits = &valueRange{}
+			}
+			if r.Min != nil && (limits.Min == nil || *r.Min < *limits.Min) {
+				limits.Min = r.Min
+			}
+			if r.Max != nil && (limits.Max == nil || *r.Max > *limits.Max) {
+				limits.Max = r.Max
+			}
+		} else if growing == nil {
+			growing = boundedRange(m, tempQualifier)
+		}
+	}
+	if growing == nil {
+		r := *limits
+		growing = &r
+	}
+
+	return growing, limits, true
+}
+
+// boundedRange 范围直接取两端, 单个数值按限定词作为上限, 下限或两者
+func boundedRange(m rangeMatch, q rangeQualifier) *valueRange {
+	from, to := m.from, m.to
+	r := &valueRange{Min: &from, Max: &to}
+	if m.single {
+		switch q.bound(m.before, m.after) {
+		case 1:
+			r.Min = nil
+		case -1:
+			r.Max = nil
+		}
+	}
+	return r
+}
+
+var (
+	periodMonthRange = regexp.MustCompile(`(\d{1,2})\s*月?\s*(?:[上中下]旬|初|底|末)?\s*(?:-\s*(?:次年|翌年|来年|第二年)?\s*(\d{1,2}))?\s*月`)
+	periodSeason     = regexp.MustCompile(`(初|早|晚|深|暮)?(春|夏|秋|冬)(初|末|季|天)?`)
+	periodSeasons    = map[string][]int{"春": {3, 4, 5}, "夏": {6, 7, 8}, "秋": {9, 10, 11}, "冬": {12, 1, 2}}
+)
+
+// parsePeriod 解析花期为月份, 按开花先后排列, 如 "12月至次年4月" 为 12, 1, 2, 3, 4; 支持季节 ("春夏", "初夏", "夏末") 和 "全年"
+func parsePeriod(s string) ([]int, bool) {
+	s = rangeSeparators.Replace(replaceNumerals(s))
+	// 只看花期, 忽略果期
+	if i := strings.Index(s, "果"); i > 0 {
+		s = s[:i]
+	}
+	if containsAny(s, []string{"全年", "四季", "常年", "周年"}) {
+		return []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}, true
+	}
+
+	type span struct {
+		pos    int
+		months []int
+	}
+	var spans []span
+	for _, loc := range periodMonthRange.FindAllStringSubmatchIndex(s, -1) {
+		from, _ := strconv.Atoi(s[loc[2]:loc[3]])
+		to := from
+		if loc[4] >= 0 {
+			to, _ = strconv.Atoi(s[loc[4]:loc[5]])
+		}
+		if from < 1 || from > 12 || to < 1 || to > 12 {
+			continue
+		}
+		var months []int
+		for m := from; ; m = m%12 + 1 {
+			months = append(months, m)
+			if m == to {
+				break
+			}
+		}
+		spans = append(spans, span{loc[0], months})
+	}
+	// 有具体月份时不再看季节, 避免 "春季3-4月" 扩大为整个春季
+	if len(spans) == 0 {
+		for _, loc := range periodSeason.FindAllStringSubmatchIndex(s, -1) {
+			months := periodSeasons[s[loc[4]:loc[5]]]
+			part := ""
+			if loc[2] >= 0 {
+				part = s[loc[2]:loc[3]]
+			} else if loc[6] >= 0 {
+				part = s[loc[6]:loc[7]]
+			}
+			switch part {
+			case "初", "早":
+				months = months[:1]
+			case "末", "晚", "深", "暮":
+				months = months[2:]
+			}
+			spans = append(spans, span{loc[0], months})
+		}
+	}
+	if len(spans) == 0 {
+		return nil, false
+	}
+
+	slices.SortStableFunc(spans, func(a, b span) int { return a.pos - b.pos })
+	var res []int
+	for _, sp := range spans {
+		for _, m := range sp.months {
+			if !slices.Contains(res, m) {
+				res = append(res, m)
+			}
+		}
+	}
+
+	return res, true
+}
+
+// normalize 将尺寸, 温度和花期解析为结构化数据, 有内容但无法解析的字段记入 Unparsed 以便人工校对
+func normalize(p *Plant) {
+	p.SizeRange, p.TempRange, p.TempLimits, p.Months, p.Unparsed = nil, nil, nil, nil, nil
+
+	var ok bool
+	if strings.TrimSpace(p.Size) != "" {
+		if p.SizeRange, ok = parseSize(p.Size); !ok {
+			p.Unparsed = append(p.Unparsed, "size")
+		}
+	}
+	if strings.TrimSpace(p.Temperature) != "" {
+		if p.TempRange, p.TempLimits, ok = parseTemperature(p.Temperature); !ok {
+			p.Unparsed = append(p.Unparsed, "temperature")
+		}
+	}
+	if strings.TrimSpace(p.Period) != "" {
+		if p.Months, ok = parsePeriod(p.Period); !ok {
+			p.Unparsed = append(p.Unparsed, "period")
+		}
+	}
+}
+
+// normalizeCatalog 为解析规则更新前保存的植物补充结构化数据
+func normalizeCatalog() {
+	plants, err := store.List()
+	if err != nil {
+		log.Println("failed to list plants for normalization:", err)
+		return
+	}
+
+	n := 0
+	for _, p := range plants {
+		plant := *p
+		normalize(&plant)
+		if plant.SizeRange.String() == p.SizeRange.String() && plant.TempRange.String() == p.TempRange.String() &&
+			plant.TempLimits.String() == p.TempLimits.String() &&
+			slices.Equal(plant.Months, p.Months) && slices.Equal(plant.Unparsed, p.Unparsed) {
+			continue
+		}
+		if err := store.Update(p.ID, &plant); err != nil {
+			log.Printf("failed to update normalized fields of %s: %v\n", p.Cnname, err)
+			continue
+		}
+		n++
+	}
+
+	if n > 0 {
+		log.Printf("normalized size, temperature and period of %d plants\n", n)
+	}
+}
+
+// rangeFilter 目录的数值范围条件, 单位为厘米和摄氏度
+type rangeFilter struct {
+	Name  string
+	Match func(p *Plant, v float64) bool
+}
+
+var rangeFilters = []rangeFilter{
+	// 能耐受 v 度的低温, 如 hardy_below=0 为可在 0℃ 以下越冬; 优先使用越冬等耐受极限, 其次为适温
+	{"hardy_below", func(p *Plant, v float64) bool { lo, ok := tempLimit(p).lo(); return ok && lo <= v }},
+	// 能耐受 v 度的高温
+	{"heat_above", func(p *Plant, v float64) bool { hi, ok := tempLimit(p).hi(); return ok && hi >= v }},
+	// 最大尺寸不超过 v 厘米, 如 size_below=50 为 50cm 以下
+	{"size_below", func(p *Plant, v float64) bool { hi, ok := p.SizeRange.hi(); return ok && hi <= v }},
+	// 最小尺寸不低于 v 厘米
+	{"size_above", func(p *Plant, v float64) bool { lo, ok := p.SizeRange.lo(); return ok && lo >= v }},
+}
+
+// tempLimit 植物能耐受的温度范围: 耐受极限与适温合并, 极限只给出一端时另一端取适温
+func tempLimit(p *Plant) *valueRange {
+	if p.TempLimits == nil {
+		return p.TempRange
+	}
+	r := *p.TempLimits
+	if r.Min == nil && p.TempRange != nil {
+		r.Min = p.TempRange.Min
+	}
+	if r.Max == nil && p.TempRange != nil {
+		r.Max = p.TempRange.Max
+	}
+	return &r
+}
+
+// parseRangeFilters 读取目录查询中的数值范围条件
+func parseRangeFilters(r *http.Request) (map[string]float64, error) {
+	res := map[string]float64{}
+	for _, f := range rangeFilters {
+		v := strings.TrimSpace(r.URL.Query().Get(f.Name))
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, &badRequestError{fmt.Errorf("invalid %s: %s", f.Name, v)}
+		}
+		res[f.Name] = n
+	}
+
+	return res, nil
+}
+
+// matchRanges 植物是否满足所有数值范围条件, 没有解析出对应数据的植物不满足
+func matchRanges(p *Plant, ranges map[string]float64) bool {
+	for _, f := range rangeFilters {
+		if v, ok := ranges[f.Name]; ok && !f.Match(p, v) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"15-30cm", "15~30", true},
+		{"1-2米", "100~200", true},
+		{"可达3m", "~300", true},
+		{"株高50厘米以上", "50~", true},
+		{"高20-40cm, 冠幅30cm", "20~40", true},
+		{"5~10mm", "0.5~1", true},
+		{"矮小", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := parseSize(tt.in)
+		if ok != tt.ok || got.String() != tt.want {
+			t.Errorf("parseSize(%q) = %q, %v, want %q, %v", tt.in, got.String(), ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseTemperature(t *testing.T) {
+	tests := []struct {
+		in      string
+		growing string
+		limits  string
+		ok      bool
+	}{
+		{"-25-35°C", "-25~35", "", true},
+		{"18-28℃", "18~28", "", true},
+		{"生长适温18-28℃, 越冬不低于5℃", "18~28", "5~", true},
+		{"15～25度, 耐寒-10℃, 耐热35℃", "15~25", "-10~35", true},
+		{"耐寒, 零下10度", "-10~-10", "", true}, // 限定词与数值不在同一子句
+		{"耐寒-10℃", "-10~", "-10~", true},
+		{"60-80°F", "15.6~26.7", "", true},
+		{"喜温暖", "", "", false},
+	}
+	for _, tt := range tests {
+		growing, limits, ok := parseTemperature(tt.in)
+		if ok != tt.ok || growing.String() != tt.growing || limits.String() != tt.limits {
+			t.Errorf("parseTemperature(%q) = %q, %q, %v, want %q, %q, %v", tt.in, growing.String(), limits.String(), ok, tt.growing, tt.limits, tt.ok)
+		}
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []int
+		ok   bool
+	}{
+		{"4-6月", []int{4, 5, 6}, true},
+		{"十一月至次年二月", []int{11, 12, 1, 2}, true},
+		{"春季", []int{3, 4, 5}, true},
+		{"全年", []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}, true},
+		{"很少开花", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := parsePeriod(tt.in)
+		if ok != tt.ok || !slices.Equal(got, tt.want) {
+			t.Errorf("parsePeriod(%q) = %v, %v, want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMatchRangesUsesLimits(t *testing.T) {
+	p := &Plant{Temperature: "生长适温18-28℃, 越冬不低于5℃"}
+	normalize(p)
+	if !matchRanges(p, map[string]float64{"hardy_below": 5}) {
+		t.Errorf("hardy_below=5 should match a plant overwintering at 5℃")
+	}
+	if matchRanges(p, map[string]float64{"hardy_below": 0}) {
+		t.Errorf("hardy_below=0 should not match a plant overwintering at 5℃")
+	}
+	if !matchRanges(p, map[string]float64{"heat_above": 28}) {
+		t.Errorf("heat_above=28 should fall back to the growing range")
+	}
+}
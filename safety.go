@@ -0,0 +1,310 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// 毒性影响的对象
+const (
+	speciesCat   = "cat"
+	speciesDog   = "dog"
+	speciesHuman = "human"
+)
+
+var speciesList = []string{speciesCat, speciesDog, speciesHuman}
+
+// Toxicology 结构化的毒性: 对猫, 狗和人的毒性等级 (无, 低, 中, 高), 有毒部位和中毒症状, 由大模型查询填充
+type Toxicology struct {
+	Cats     string   `json:"cats"`
+	Dogs     string   `json:"dogs"`
+	Humans   string   `json:"humans"`
+	Parts    []string `json:"parts"`    // 有毒部位, 如 全株, 叶, 球茎, 汁液
+	Symptoms []string `json:"symptoms"` // 中毒症状, 如 呕吐, 心律不齐
+}
+
+// toxicologySchema 大模型回答 toxicology 字段的 json schema
+func toxicologySchema() map[string]any {
+	level := func(desc string) map[string]any {
+		return map[string]any{"type": "string", "enum": toxicityLevels, "description": desc}
+	}
+	list := func(desc string) map[string]any {
+		return map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": desc}
+	}
+
+	return map[string]any{
+		"type":        "object",
+		"description": "毒性详情",
+		"properties": map[string]any{
+			"cats":     level("对猫的毒性等级"),
+			"dogs":     level("对狗的毒性等级"),
+			"humans":   level("对人 (包括误食的儿童) 的毒性等级"),
+			"parts":    list("有毒部位, 如全株, 根, 叶, 花, 果实, 种子, 汁液, 无毒时为空数组"),
+			"symptoms": list("中毒症状, 无毒时为空数组"),
+		},
+		"required":             []string{"cats", "dogs", "humans", "parts", "symptoms"},
+		"additionalProperties": false,
+	}
+}
+
+// Level 对 species 的毒性等级
+func (t *Toxicology) Level(species string) string {
+	switch species {
+	case speciesCat:
+		return t.Cats
+	case speciesDog:
+		return t.Dogs
+	case speciesHuman:
+		return t.Humans
+	}
+	return ""
+}
+
+// clean 去掉首尾空白, 空项和重复项
+func (t *Toxicology) clean() {
+	for _, s := range []*string{&t.Cats, &t.Dogs, &t.Humans} {
+		*s = strings.TrimSpace(*s)
+	}
+	for _, list := range []*[]string{&t.Parts, &t.Symptoms} {
+		var res []string
+		for _, v := range *list {
+			if v = strings.TrimSpace(v); v != "" && !slices.Contains(res, v) {
+				res = append(res, v)
+			}
+		}
+		*list = res
+	}
+}
+
+// validate 毒性等级必须为 无, 低, 中, 高 之一, 返回字段名到错误原因的映射
+func (t *Toxicology) validate() map[string]string {
+	fields := map[string]string{}
+	for name, v := range map[string]string{"cats": t.Cats, "dogs": t.Dogs, "humans": t.Humans} {
+		if !slices.Contains(toxicityLevels, v) {
+			fields[name] = fmt.Sprintf("%q must be one of %s", v, strings.Join(toxicityLevels, ", "))
+		}
+	}
+	return fields
+}
+
+// max 对各物种毒性等级中最高的一个
+func (t *Toxicology) max() string {
+	level := toxicityLevels[0]
+	for _, s := range speciesList {
+		if v := t.Level(s); slices.Contains(toxicityLevels, v) && levelIndex(toxicityLevels, v) > levelIndex(toxicityLevels, level) {
+			level = v
+		}
+	}
+	return level
+}
+
+// toxicityFor 植物对 species 的毒性等级; 没有结构化毒性的旧数据按毒性评级估计
+func toxicityFor(p *Plant, species string) (level string, estimated bool) {
+	if p.Toxicology != nil {
+		return p.Toxicology.Level(species), false
+	}
+	return p.Itoxicity, true
+}
+
+// householdMember 家庭中需要注意植物毒性的成员
+type householdMember struct {
+	Name      string `json:"name"`
+	Species   string `json:"species"`   // cat, dog, human
+	Threshold string `json:"threshold"` // 毒性达到该等级即为危险, 默认为低; 成年人可以设为中或高
+}
+
+// household 家庭配置, 家庭安全报告列出对其中任一成员危险的植物
+type household struct {
+	Name    string            `json:"name"`
+	Members []householdMember `json:"members"`
+}
+
+// defaultHousehold 没有家庭配置时假定家中有猫, 狗和儿童
+var defaultHousehold = household{Name: "default", Members: []householdMember{
+	{Name: "猫", Species: speciesCat, Threshold: "低"},
+	{Name: "狗", Species: speciesDog, Threshold: "低"},
+	{Name: "儿童", Species: speciesHuman, Threshold: "低"},
+}}
+
+var currentHousehold = defaultHousehold
+
+// loadHouseholdConfig 读取家庭配置, path 为空时使用默认配置
+func loadHouseholdConfig(path string) (household, error) {
+	if path == "" {
+		return defaultHousehold, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return household{}, fmt.Errorf("failed to read household config: %w", err)
+	}
+	var h household
+	if err := json.Unmarshal(data, &h); err != nil {
+		return household{}, fmt.Errorf("failed to unmarshal household config: %w", err)
+	}
+	if err := prepareHousehold(&h); err != nil {
+		return household{}, fmt.Errorf("invalid household config: %w", err)
+	}
+
+	return h, nil
+}
+
+func prepareHousehold(h *household) error {
+	if len(h.Members) == 0 {
+		return fmt.Errorf("no members")
+	}
+	for i := range h.Members {
+		m := &h.Members[i]
+		if !slices.Contains(speciesList, m.Species) {
+			return fmt.Errorf("member %d: invalid species %q (must be one of %s)", i, m.Species, strings.Join(speciesList, ", "))
+		}
+		if m.Threshold == "" {
+			m.Threshold = "低"
+		}
+		if !slices.Contains(toxicityLevels[1:], m.Threshold) {
+			return fmt.Errorf("member %d: invalid threshold %q (must be one of %s)", i, m.Threshold, strings.Join(toxicityLevels[1:], ", "))
+		}
+		if m.Name == "" {
+			m.Name = m.Species
+		}
+	}
+	return nil
+}
+
+// safetyRisk 植物对一位家庭成员的危险
+type safetyRisk struct {
+	Member  string `json:"member"`
+	Species string `json:"species"`
+	Level   string `json:"level"`
+}
+
+// safetyItem 家庭安全报告中的一种危险植物, 或我的植物中的一株
+type safetyItem struct {
+	PlantID    string       `json:"plant_id"`
+	SpecimenID string       `json:"specimen_id,omitempty"`
+	Name       string       `json:"name"`
+	Location   string       `json:"location,omitempty"`
+	Level      string       `json:"level"` // 对家庭成员的最高毒性等级
+	Risks      []safetyRisk `json:"risks"`
+	Parts      []string     `json:"parts,omitempty"`
+	Symptoms   []string     `json:"symptoms,omitempty"`
+	Estimated  bool         `json:"estimated,omitempty"` // 没有结构化毒性, 按毒性描述估计
+}
+
+// assess 植物对家庭成员的危险, 不危险时返回 nil
+func (h *household) assess(p *Plant) *safetyItem {
+	item := &safetyItem{PlantID: p.ID, Name: p.Cnname, Level: toxicityLevels[0]}
+	for _, m := range h.Members {
+		level, estimated := toxicityFor(p, m.Species)
+		if !slices.Contains(toxicityLevels, level) || levelIndex(toxicityLevels, level) < levelIndex(toxicityLevels, m.Threshold) {
+			continue
+		}
+		item.Risks = append(item.Risks, safetyRisk{Member: m.Name, Species: m.Species, Level: level})
+		item.Estimated = estimated
+		if levelIndex(toxicityLevels, level) > levelIndex(toxicityLevels, item.Level) {
+			item.Level = level
+		}
+	}
+	if len(item.Risks) == 0 {
+		return nil
+	}
+	if p.Toxicology != nil {
+		item.Parts, item.Symptoms = p.Toxicology.Parts, p.Toxicology.Symptoms
+	}
+
+	return item
+}
+
+// safetyReport 家庭安全报告
+type safetyReport struct {
+	Household household    `json:"household"`
+	Source    string       `json:"source"`
+	Checked   int          `json:"checked"` // 检查的植物数量
+	Dangerous []safetyItem `json:"dangerous"`
+}
+
+// householdReport 检查我的植物 (source 为 collection) 或整个目录 (catalog), 危险的按毒性从高到低排列
+func householdReport(h household, source string) (*safetyReport, error) {
+	report := &safetyReport{Household: h, Source: source, Dangerous: []safetyItem{}}
+	if source == "catalog" {
+		plants, err := store.List()
+		if err != nil {
+			return nil, err
+		}
+		report.Checked = len(plants)
+		for _, p := range plants {
+			if item := h.assess(p); item != nil {
+				report.Dangerous = append(report.Dangerous, *item)
+			}
+		}
+	} else {
+		specimens, err := collection.List()
+		if err != nil {
+			return nil, err
+		}
+		report.Checked = len(specimens)
+		for _, s := range specimens {
+			p, err := store.Get(s.PlantID)
+			if err != nil {
+				return nil, err
+			}
+			if item := h.assess(p); item != nil {
+				item.SpecimenID, item.Name, item.Location = s.ID, s.Nickname, s.Location
+				report.Dangerous = append(report.Dangerous, *item)
+			}
+		}
+	}
+
+	keys := map[string]string{}
+	for _, item := range report.Dangerous {
+		keys[item.Name] = pinyinKey(item.Name)
+	}
+	slices.SortStableFunc(report.Dangerous, func(a, b safetyItem) int {
+		if c := cmp.Compare(levelIndex(toxicityLevels, b.Level), levelIndex(toxicityLevels, a.Level)); c != 0 {
+			return c
+		}
+		return cmp.Compare(keys[a.Name], keys[b.Name])
+	})
+
+	return report, nil
+}
+
+// safetyHandler 家庭安全报告: source 为 collection (默认) 或 catalog; species 为逗号分隔的 cat, dog, human 时
+// 临时以这些物种 (阈值为低) 代替配置的家庭成员
+func safetyHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	source := q.Get("source")
+	switch source {
+	case "":
+		source = "collection"
+	case "collection", "catalog":
+	default:
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid source: "+source+" (must be collection or catalog)", nil)
+		return
+	}
+
+	h := currentHousehold
+	if v := strings.TrimSpace(q.Get("species")); v != "" {
+		h = household{Name: "custom"}
+		for _, s := range strings.Split(v, ",") {
+			h.Members = append(h.Members, householdMember{Species: strings.TrimSpace(s)})
+		}
+		if err := prepareHousehold(&h); err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", err.Error(), nil)
+			return
+		}
+	}
+
+	report, err := householdReport(h, source)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
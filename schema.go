@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// lookupFields 大模型需要回答的字段说明, 其余 Plant 字段 (id, 派生等级, 图片) 由服务端填充; 结构化字段见 lookupObjects
+var lookupFields = map[string]string{
+	"cnname":        "中文名",
+	"enname":        "英文学名",
+	"genus":         "科属, 格式为xx科xx属",
+	"category":      "类别, 草本明确几年生, 木本明确是乔木灌木还是藤木",
+	"habit":         "习性, 生态喜好和忌讳",
+	"distribution":  "分布",
+	"size":          "尺寸, 格式为xx-xxcm",
+	"toxicity":      "毒性",
+	"period":        "花期, 明确月份",
+	"light":         "光照, 明确喜光度",
+	"temperature":   "温度, 格式为xx-xx°C",
+	"watering":      "浇水, 明确周期",
+	"fertilization": "施肥, 明确周期",
+	"notes":         "简介, 此植物的特色内涵用途等",
+	"link":          "百科, 中文维基百科的链接",
+}
+
+// lookupObjects 大模型需要回答的结构化字段及其 json schema
+var lookupObjects = map[string]func() map[string]any{
+	"toxicology": toxicologySchema,
+}
+
+// plantSchema 根据 Plant 结构体的 json tag 生成单个植物的 json schema
+func plantSchema() map[string]any {
+	props := map[string]any{}
+	required := []string{}
+
+	t := reflect.TypeOf(Plant{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if schema, ok := lookupObjects[name]; ok {
+			props[name] = schema()
+			required = append(required, name)
+			continue
+		}
+		desc, ok := lookupFields[name]
+		if !ok {
+			continue
+		}
+
+		props[name] = map[string]any{"type": "string", "description": desc}
+		required = append(required, name)
+	}
+
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+// lookupSchema 查询结果的 json schema, 结构化输出要求根节点为对象, 因此以 plants 数组包裹
+func lookupSchema() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"plants": map[string]any{
+				"type":  "array",
+				"items": plantSchema(),
+			},
+		},
+		"required":             []string{"plants"},
+		"additionalProperties": false,
+	}
+}
+
+// stripSchema 去掉部分厂商 (如 Gemini) 不支持的 schema 关键字
+func stripSchema(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := map[string]any{}
+		for k, val := range v {
+			if k == "additionalProperties" {
+				continue
+			}
+			m[k] = stripSchema(val)
+		}
+		return m
+	case []any:
+		s := make([]any, len(v))
+		for i, val := range v {
+			s[i] = stripSchema(val)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+var (
+	genusRegexp       = regexp.MustCompile(`^\S+科\S+属$`)
+	sizeRegexp        = regexp.MustCompile(`^\d+(\.\d+)?-\d+(\.\d+)?cm$`)
+	temperatureRegexp = regexp.MustCompile(`^-?\d+(\.\d+)?--?\d+(\.\d+)?°C$`)
+)
+
+// validateLookup 严格校验大模型返回的字段格式, 返回字段名到错误原因的映射
+func validateLookup(p *Plant) map[string]string {
+	fields := map[string]string{}
+
+	if strings.TrimSpace(p.Cnname) == "" {
+		fields["cnname"] = "is required"
+	}
+	if strings.TrimSpace(p.Enname) == "" {
+		fields["enname"] = "is required"
+	}
+	if !genusRegexp.MatchString(p.Genus) {
+		fields["genus"] = fmt.Sprintf("%q does not match format xx科xx属", p.Genus)
+	}
+	if !sizeRegexp.MatchString(p.Size) {
+		fields["size"] = fmt.Sprintf("%q does not match format xx-xxcm", p.Size)
+	}
+	if !temperatureRegexp.MatchString(p.Temperature) {
+		fields["temperature"] = fmt.Sprintf("%q does not match format xx-xx°C", p.Temperature)
+	}
+	if p.Toxicology == nil {
+		fields["toxicology"] = "is required"
+	} else {
+		for k, v := range p.Toxicology.validate() {
+			fields["toxicology."+k] = v
+		}
+	}
+	if p.Link != "" && !strings.HasPrefix(p.Link, "http://") && !strings.HasPrefix(p.Link, "https://") {
+		fields["link"] = fmt.Sprintf("%q is not a url", p.Link)
+	}
+
+	return fields
+}
+
+// parsePlants 解析并校验大模型的回答, 兼容 {"plants": [...]}, 数组和单个对象三种形式
+func parsePlants(content string) ([]*Plant, error) {
+	cont := []byte(extractJSON(content))
+
+	var pls []*Plant
+	var wrapped struct {
+		Plants []*Plant `json:"plants"`
+	}
+	if err := json.Unmarshal(cont, &wrapped); err == nil && wrapped.Plants != nil {
+		pls = wrapped.Plants
+	} else if err := json.Unmarshal(cont, &pls); err != nil {
+		var pl *Plant
+		if err := json.Unmarshal(cont, &pl); err != nil || pl == nil {
+			return nil, &validationError{Fields: map[string]string{"json": fmt.Sprintf("invalid json: %v", err)}}
+		}
+		pls = []*Plant{pl}
+	}
+
+	// 空数组表示模型认为没有此植物, 不再重试
+	if len(pls) == 0 {
+		return nil, nil
+	}
+
+	fields := map[string]string{}
+	for i, p := range pls {
+		if p == nil {
+			fields[fmt.Sprintf("plants[%d]", i)] = "is null"
+			continue
+		}
+		for k, v := range validateLookup(p) {
+			fields[fmt.Sprintf("plants[%d].%s", i, k)] = v
+		}
+	}
+	if len(fields) > 0 {
+		return pls, &validationError{Fields: fields}
+	}
+
+	return pls, nil
+}
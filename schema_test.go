@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+const validPlantJSON = `{"cnname":"绿萝","enname":"Pothos","genus":"天南星科麒麟叶属","size":"20-200cm","temperature":"15-30°C",` +
+	`"toxicology":{"cats":"中","dogs":"中","humans":"低","parts":["叶"],"symptoms":["呕吐"]}}`
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`{"a":1}`, `{"a":1}`},
+		{"```json\n{\"a\":1}\n```", `{"a":1}`},
+		{"以下是结果:\n```\n[1]\n```\n希望有帮助", `[1]`},
+		{"  ```json{\"a\":1}```  ", `{"a":1}`},
+	}
+	for _, tt := range tests {
+		if got := extractJSON(tt.in); got != tt.want {
+			t.Errorf("extractJSON(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParsePlants(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		count   int
+		fields  []string // 未通过校验的字段, 为空表示通过
+	}{
+		{"wrapped", `{"plants":[` + validPlantJSON + `]}`, 1, nil},
+		{"array", `[` + validPlantJSON + `,` + validPlantJSON + `]`, 2, nil},
+		{"object", validPlantJSON, 1, nil},
+		{"markdown", "```json\n" + validPlantJSON + "\n```", 1, nil},
+		{"empty", `{"plants":[]}`, 0, nil},
+		{"invalid json", `{"plants":`, 0, []string{"json"}},
+		{"null item", `[null]`, 1, []string{"plants[0]"}},
+		{"bad genus", `[{"cnname":"绿萝","enname":"Pothos","genus":"天南星科","size":"20-200cm","temperature":"15-30°C",` +
+			`"toxicology":{"cats":"中","dogs":"中","humans":"低","parts":[],"symptoms":[]}}]`, 1, []string{"plants[0].genus"}},
+		{"missing toxicology", `[{"cnname":"绿萝","enname":"Pothos","genus":"天南星科麒麟叶属","size":"20-200cm","temperature":"15-30°C"}]`,
+			1, []string{"plants[0].toxicology"}},
+	}
+	for _, tt := range tests {
+		pls, err := parsePlants(tt.content)
+		if len(pls) != tt.count {
+			t.Errorf("%s: got %d plants, want %d", tt.name, len(pls), tt.count)
+		}
+		var verr *validationError
+		if len(tt.fields) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		if !errors.As(err, &verr) {
+			t.Errorf("%s: error = %v, want a validation error", tt.name, err)
+			continue
+		}
+		for _, f := range tt.fields {
+			if _, ok := verr.Fields[f]; !ok {
+				t.Errorf("%s: missing field %s in %v", tt.name, f, verr.Fields)
+			}
+		}
+	}
+}
+
+func TestValidateLookup(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(p *Plant)
+		field  string
+	}{
+		{"valid", func(p *Plant) {}, ""},
+		{"negative temperature", func(p *Plant) { p.Temperature = "-5-30°C" }, ""},
+		{"no cnname", func(p *Plant) { p.Cnname = " " }, "cnname"},
+		{"size unit", func(p *Plant) { p.Size = "20-200mm" }, "size"},
+		{"temperature unit", func(p *Plant) { p.Temperature = "15-30℃" }, "temperature"},
+		{"toxicity level", func(p *Plant) { p.Toxicology.Cats = "很高" }, "toxicology.cats"},
+		{"link", func(p *Plant) { p.Link = "example.com" }, "link"},
+	}
+	for _, tt := range tests {
+		pls, err := parsePlants(validPlantJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tt.modify(pls[0])
+		fields := validateLookup(pls[0])
+		if tt.field == "" {
+			if len(fields) > 0 {
+				t.Errorf("%s: unexpected errors %v", tt.name, fields)
+			}
+			continue
+		}
+		if _, ok := fields[tt.field]; !ok || len(fields) != 1 {
+			t.Errorf("%s: errors = %v, want only %s", tt.name, fields, tt.field)
+		}
+	}
+}
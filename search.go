@@ -0,0 +1,408 @@
+package main
+
+import (
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/mozillazg/go-pinyin"
+)
+
+// searchField 参与搜索的字段及其权重, 名称的权重最高
+type searchField struct {
+	Name   string
+	Weight float64
+	Value  func(p *Plant) string
+	Pinyin bool // 是否支持拼音和拼音首字母匹配
+}
+
+var searchFields = []searchField{
+	{"cnname", 10, func(p *Plant) string { return p.Cnname }, true},
+	{"enname", 8, func(p *Plant) string { return p.Enname }, false},
+	{"slug", 4, func(p *Plant) string { return p.Slug }, false},
+	{"genus", 5, func(p *Plant) string { return p.Genus }, true},
+	{"category", 3, func(p *Plant) string { return p.Category }, false},
+	{"distribution", 2, func(p *Plant) string { return p.Distribution }, false},
+	{"habit", 2, func(p *Plant) string { return p.Habit }, false},
+	{"period", 1.5, func(p *Plant) string { return p.Period }, false},
+	{"light", 1.5, func(p *Plant) string { return p.Light }, false},
+	{"toxicity", 1.5, func(p *Plant) string { return p.Toxicity }, false},
+	{"notes", 1, func(p *Plant) string { return p.Notes }, false},
+	{"watering", 1, func(p *Plant) string { return p.Watering }, false},
+	{"fertilization", 1, func(p *Plant) string { return p.Fertilization }, false},
+	{"size", 0.5, func(p *Plant) string { return p.Size }, false},
+	{"temperature", 0.5, func(p *Plant) string { return p.Temperature }, false},
+}
+
+// searchDoc 一种植物的索引: 每个字段的词项, 以及支持拼音的字段每个汉字的读音 (含多音字)
+type searchDoc struct {
+	Plant  *Plant
+	Text   []string           // 小写后的字段原文, 用于整词命中加分
+	Terms  []map[string]bool  // 字段 -> 词项
+	Pinyin map[int][][]string // 字段 -> 每个汉字的读音
+}
+
+// searchIndex 植物目录的内存索引, 目录内容变化时在下一次搜索前重建
+type searchIndex struct {
+	mu          sync.Mutex
+	fingerprint uint64
+	docs        []*searchDoc
+}
+
+var plantIndex = &searchIndex{}
+
+var pinyinArgs = func() pinyin.Args {
+	a := pinyin.NewArgs()
+	a.Heteronym = true
+	return a
+}()
+
+func isHan(r rune) bool {
+	return unicode.Is(unicode.Han, r)
+}
+
+// searchTokens 切分文本: 英文和数字按单词切分, 连续的汉字切分为单字和相邻两字 (二元分词), 不依赖词典
+func searchTokens(text string) []string {
+	var tokens []string
+	var word []rune
+	var han []rune
+	flush := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+		for i := range han {
+			tokens = append(tokens, string(han[i]))
+			if i+1 < len(han) {
+				tokens = append(tokens, string(han[i:i+2]))
+			}
+		}
+		han = han[:0]
+	}
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case isHan(r):
+			if len(word) > 0 {
+				tokens = append(tokens, string(word))
+				word = word[:0]
+			}
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if len(han) > 0 {
+				flush()
+			}
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+func newSearchDoc(p *Plant) *searchDoc {
+	d := &searchDoc{Plant: p, Pinyin: map[int][][]string{}}
+	for i, f := range searchFields {
+		v := f.Value(p)
+		d.Text = append(d.Text, strings.ToLower(v))
+		terms := map[string]bool{}
+		for _, t := range searchTokens(v) {
+			terms[t] = true
+		}
+		d.Terms = append(d.Terms, terms)
+
+		if f.Pinyin {
+			var syllables [][]string
+			for _, r := range v {
+				if isHan(r) {
+					if py := pinyin.SinglePinyin(r, pinyinArgs); len(py) > 0 {
+						syllables = append(syllables, slices.Compact(py))
+					}
+				}
+			}
+			d.Pinyin[i] = syllables
+		}
+	}
+
+	return d
+}
+
+// catalogFingerprint 目录中所有可搜索文本的哈希, 用于判断索引是否需要重建
+func catalogFingerprint(plants []*Plant) uint64 {
+	h := fnv.New64a()
+	for _, p := range plants {
+		h.Write([]byte(p.ID))
+		for _, f := range searchFields {
+			h.Write([]byte{0})
+			h.Write([]byte(f.Value(p)))
+		}
+		h.Write([]byte{1})
+	}
+
+	return h.Sum64()
+}
+
+// refresh 目录有变化时重建索引, 返回当前的文档
+func (ix *searchIndex) refresh() ([]*searchDoc, error) {
+	plants, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if fp := catalogFingerprint(plants); ix.docs == nil || fp != ix.fingerprint {
+		docs := make([]*searchDoc, 0, len(plants))
+		for _, p := range plants {
+			docs = append(docs, newSearchDoc(p))
+		}
+		ix.docs, ix.fingerprint = docs, fp
+	} else {
+		// 文本未变时仍使用最新的植物, 图片等字段可能已更新. 其他请求可能正在读取旧的文档,
+		// 因此复制文档后替换整个切片, 不修改旧文档
+		docs := make([]*searchDoc, 0, len(plants))
+		for i, p := range plants {
+			d := *ix.docs[i]
+			d.Plant = p
+			docs = append(docs, &d)
+		}
+		ix.docs = docs
+	}
+
+	return ix.docs, nil
+}
+
+// pinyinMatch q 是否匹配从 start 开始的连续读音: 每个音节取全拼或前缀, 只有最后一个匹配的音节可以是不完整的全拼,
+// 因此 "ll", "linglan", "lingl", "lingla" 都能匹配铃兰
+func pinyinMatch(q string, syllables [][]string, start int) bool {
+	if q == "" {
+		return true
+	}
+	if start >= len(syllables) {
+		return false
+	}
+	for _, py := range syllables[start] {
+		// 整个音节
+		if rest, ok := strings.CutPrefix(q, py); ok && pinyinMatch(rest, syllables, start+1) {
+			return true
+		}
+		// 音节前缀 (首字母或部分拼音)
+		for n := len(py) - 1; n >= 1; n-- {
+			if rest, ok := strings.CutPrefix(q, py[:n]); ok && pinyinMatch(rest, syllables, start+1) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// editDistance 两个字符串的编辑距离 (含相邻交换), 超过 limit 时提前返回 limit+1
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > limit || -d > limit {
+		return limit + 1
+	}
+
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			best = min(best, cur[j])
+		}
+		if best > limit {
+			return limit + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+
+	return prev[len(rb)]
+}
+
+// typoLimit 英文单词允许的拼写错误数, 短词不做模糊匹配
+func typoLimit(word string) int {
+	switch n := len([]rune(word)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// scoreTerm 计算一个查询词在文档中的得分和命中的字段, 未命中时得分为 0
+func (d *searchDoc) scoreTerm(q string) (float64, []string) {
+	var score float64
+	var fields []string
+	hasHan := strings.IndexFunc(q, isHan) >= 0
+	qTokens := searchTokens(q)
+
+	for i, f := range searchFields {
+		var s float64
+		switch {
+		case d.Text[i] == q:
+			s = 3
+		case hasHan && strings.Contains(d.Text[i], q):
+			s = 2
+		case hasHan:
+			// 汉字不完整包含时按命中的二元词项比例计分; 中文名再按单字兜底, 容忍输错个别字
+			var bigrams, bigramHits, chars, charHits int
+			for _, t := range qTokens {
+				if len([]rune(t)) == 1 {
+					chars++
+					if d.Terms[i][t] {
+						charHits++
+					}
+					continue
+				}
+				bigrams++
+				if d.Terms[i][t] {
+					bigramHits++
+				}
+			}
+			if bigrams > 0 && bigramHits*2 >= bigrams {
+				s = float64(bigramHits) / float64(bigrams)
+			} else if f.Name == "cnname" && chars > 1 && charHits*2 >= chars {
+				s = 0.3 * float64(charHits) / float64(chars)
+			}
+		default:
+			for t := range d.Terms[i] {
+				switch {
+				case t == q:
+					s = max(s, 1.5)
+				case len(q) >= 2 && strings.HasPrefix(t, q):
+					s = max(s, 1)
+				case typoLimit(q) > 0 && strings.IndexFunc(t, isHan) < 0 && editDistance(q, t, typoLimit(q)) <= typoLimit(q):
+					s = max(s, 0.6)
+				}
+			}
+			if syllables := d.Pinyin[i]; s == 0 && len(syllables) > 0 {
+				for start := range syllables {
+					if pinyinMatch(q, syllables, start) {
+						// 从第一个字开始匹配的排在前面
+						s = 1.2
+						if start > 0 {
+							s = 0.8
+						}
+						break
+					}
+				}
+			}
+		}
+
+		if s > 0 {
+			score += s * f.Weight
+			fields = append(fields, f.Name)
+		}
+	}
+
+	return score, fields
+}
+
+// searchHit 一条搜索结果
+type searchHit struct {
+	Score  float64  `json:"score"`
+	Fields []string `json:"fields"` // 命中的字段
+	Plant  *Plant   `json:"plant"`
+}
+
+// Search 按空白切分查询, 每个词都要命中, 按得分从高到低返回
+func (ix *searchIndex) Search(query string, limit int) ([]searchHit, error) {
+	docs, err := ix.refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	terms := strings.Fields(strings.ToLower(query))
+	hits := []searchHit{}
+	if len(terms) == 0 {
+		return hits, nil
+	}
+
+	for _, d := range docs {
+		hit := searchHit{Plant: d.Plant}
+		for _, t := range terms {
+			s, fields := d.scoreTerm(t)
+			if s == 0 {
+				hit.Score = 0
+				break
+			}
+			hit.Score += s
+			for _, f := range fields {
+				if !slices.Contains(hit.Fields, f) {
+					hit.Fields = append(hit.Fields, f)
+				}
+			}
+		}
+		if hit.Score > 0 {
+			hits = append(hits, hit)
+		}
+	}
+
+	slices.SortStableFunc(hits, func(a, b searchHit) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Plant.Cnname, b.Plant.Cnname)
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	for i := range hits {
+		hits[i].Score = round1(hits[i].Score)
+	}
+
+	return hits, nil
+}
+
+// searchHandler 搜索植物目录: q 为查询 (中文, 英文, 拼音或拼音首字母), limit 默认 50
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := strings.TrimSpace(q.Get("q"))
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "please input search query", nil)
+		return
+	}
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "bad_request", "invalid limit: "+v, nil)
+			return
+		}
+		limit = n
+	}
+
+	hits, err := plantIndex.Search(query, limit)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, hits)
+}
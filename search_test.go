@@ -0,0 +1,134 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestSearchTokens(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"Lily of the Valley", []string{"lily", "of", "the", "valley"}},
+		{"铃兰", []string{"铃", "铃兰", "兰"}},
+		{"龟背竹Monstera", []string{"龟", "龟背", "背", "背竹", "竹", "monstera"}},
+		{"天南星科, 15-30cm", []string{"天", "天南", "南", "南星", "星", "星科", "科", "15", "30cm"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := searchTokens(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("searchTokens(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"monstera", "monstera", 2, 0},
+		{"monstera", "monstra", 2, 1},
+		{"monstera", "mosntera", 2, 1}, // 相邻交换
+		{"pothos", "photos", 2, 2},
+		{"kitten", "sitting", 3, 3},
+		{"kitten", "sitting", 2, 3}, // 超过 limit 时返回 limit+1
+		{"a", "abcdef", 2, 3},
+		{"绿萝", "绿箩", 1, 1},
+		{"", "ab", 2, 2},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b, tt.limit); got != tt.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestPinyinMatch(t *testing.T) {
+	syllables := newSearchDoc(&Plant{Cnname: "铃兰"}).Pinyin[0]
+	tests := []struct {
+		q    string
+		want bool
+	}{
+		{"linglan", true},
+		{"ll", true},
+		{"lingl", true},
+		{"lingla", true},
+		{"lin", true},
+		{"lan", false},
+		{"linglang", false},
+		{"lx", false},
+	}
+	for _, tt := range tests {
+		if got := pinyinMatch(tt.q, syllables, 0); got != tt.want {
+			t.Errorf("pinyinMatch(%q, 铃兰) = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	s, err := openStore("json", filepath.Join(t.TempDir(), "plants.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(old PlantStore) { store = old }(store)
+	store = s
+	for _, p := range []*Plant{
+		{Cnname: "铃兰", Enname: "Lily of the Valley", Genus: "天门冬科铃兰属"},
+		{Cnname: "绿萝", Enname: "Pothos", Genus: "天南星科麒麟叶属"},
+		{Cnname: "龟背竹", Enname: "Monstera", Genus: "天南星科龟背竹属"},
+	} {
+		if err := store.Put(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"铃兰", []string{"铃兰"}},
+		{"ll", []string{"绿萝", "铃兰"}}, // 绿萝的首字母也是 ll
+		{"lingl", []string{"铃兰"}},
+		{"gbz", []string{"龟背竹"}},
+		{"monstra", []string{"龟背竹"}},
+		{"天南星", []string{"绿萝", "龟背竹"}},
+		{"天南星 pothos", []string{"绿萝"}},
+		{"仙人掌", nil},
+	}
+	for _, tt := range tests {
+		hits, err := plantIndex.Search(tt.q, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, h := range hits {
+			got = append(got, h.Plant.Cnname)
+		}
+		slices.Sort(got)
+		want := slices.Sorted(slices.Values(tt.want))
+		if !slices.Equal(got, want) {
+			t.Errorf("Search(%q) = %q, want %q", tt.q, got, want)
+		}
+	}
+
+	// 并发搜索时刷新索引不能修改其他请求正在读取的文档
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				hits, _ := plantIndex.Search("ll", 10)
+				for _, h := range hits {
+					_ = h.Plant.Image
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
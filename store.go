@@ -0,0 +1,750 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+var (
+	errPlantNotFound  = errors.New("plant not found")
+	errPlantExists    = errors.New("plant already exists")
+	errPlantAmbiguous = errors.New("multiple plants match the name, please use id")
+)
+
+// PlantStore 植物目录的存储后端.
+// key 优先按 id 和 slug 查找, 其次按中文名, 最后按英文名, 名称匹配到多条记录时返回 errPlantAmbiguous
+type PlantStore interface {
+	Get(key string) (*Plant, error)
+	List() ([]*Plant, error)
+	Put(plant *Plant) error
+	Update(key string, plant *Plant) error
+	// Modify 读取当前的植物, 由 fn 修改后写回, 读写之间不会插入其他修改; fn 返回错误时不写入
+	Modify(key string, fn func(plant *Plant) error) (*Plant, error)
+	Delete(key string) error
+	Close() error
+}
+
+var store PlantStore
+
+func openStore(kind, path string, backups int) (PlantStore, error) {
+	switch kind {
+	case "", "json":
+		if path == "" {
+			path = "plants.json"
+		}
+		return openJSONStore(path, backups)
+	case "sqlite":
+		if path == "" {
+			path = "plants.db"
+		}
+		return openSQLiteStore(path)
+	default:
+		return nil, fmt.Errorf("unsupported store type: %s", kind)
+	}
+}
+
+// duplicate 中文名和英文名都相同视为同一植物, 仅同名的不同植物可以共存
+func duplicate(a, b *Plant) bool {
+	return a.Cnname == b.Cnname && a.Enname == b.Enname
+}
+
+// jsonStore 将整个目录保存在一个 json 文件中, 读写由 mu 保护,
+// 写入时先写临时文件再 rename, 并保留最近 backups 个历史版本
+type jsonStore struct {
+	mu      sync.RWMutex
+	path    string
+	backups int
+	plants  []*Plant
+}
+
+func openJSONStore(path string, backups int) (*jsonStore, error) {
+	s := &jsonStore{path: path, backups: backups, plants: []*Plant{}}
+
+	plants, err := readPlantsFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Println("plant file not exist, starting with empty catalog:", path)
+			return s, nil
+		}
+		return nil, err
+	}
+	s.plants = plants
+
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// readPlantsFile 读取 json 文件中的植物, 不修改文件; 文件不存在时返回的错误满足 os.IsNotExist
+func readPlantsFile(path string) ([]*Plant, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	plants := []*Plant{}
+	if err := json.Unmarshal(data, &plants); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal json: %w", err)
+	}
+
+	return plants, nil
+}
+
+// migrate 为旧版本文件中缺少 id 的植物补充 id 和 slug
+func (s *jsonStore) migrate() error {
+	n := 0
+	for _, p := range s.plants {
+		if p.ID == "" || p.Slug == "" {
+			assignID(p, s.slugTaken)
+			n++
+		}
+	}
+	if n == 0 || s.path == "" {
+		return nil
+	}
+
+	log.Printf("assigned ids to %d plants in %s\n", n, s.path)
+	return s.flush(s.plants)
+}
+
+func (s *jsonStore) slugTaken(slug string) bool {
+	return slices.ContainsFunc(s.plants, func(p *Plant) bool { return p.Slug == slug })
+}
+
+func (s *jsonStore) index(key string) (int, error) {
+	if key == "" {
+		return -1, errPlantNotFound
+	}
+
+	if idx := slices.IndexFunc(s.plants, func(p *Plant) bool { return p.ID == key || p.Slug == key }); idx >= 0 {
+		return idx, nil
+	}
+
+	for _, name := range []func(*Plant) string{
+		func(p *Plant) string { return p.Cnname },
+		func(p *Plant) string { return p.Enname },
+	} {
+		found := -1
+		for i, p := range s.plants {
+			if name(p) != key {
+				continue
+			}
+			if found >= 0 {
+				return -1, errPlantAmbiguous
+			}
+			found = i
+		}
+		if found >= 0 {
+			return found, nil
+		}
+	}
+
+	return -1, errPlantNotFound
+}
+
+func (s *jsonStore) Get(key string) (*Plant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	idx, err := s.index(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.plants[idx].clone(), nil
+}
+
+func (s *jsonStore) List() ([]*Plant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pls := make([]*Plant, 0, len(s.plants))
+	for _, p := range s.plants {
+		pls = append(pls, p.clone())
+	}
+
+	return pls, nil
+}
+
+func (s *jsonStore) Put(plant *Plant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if slices.ContainsFunc(s.plants, func(p *Plant) bool { return p.ID == plant.ID || duplicate(p, plant) }) {
+		return errPlantExists
+	}
+
+	assignID(plant, s.slugTaken)
+	p := *plant
+	if err := s.flush(append(slices.Clone(s.plants), &p)); err != nil {
+		return err
+	}
+
+	s.plants = append(s.plants, &p)
+	return nil
+}
+
+func (s *jsonStore) Update(key string, plant *Plant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx, err := s.index(key)
+	if err != nil {
+		return err
+	}
+
+	for i, p := range s.plants {
+		if i != idx && duplicate(p, plant) {
+			return errPlantExists
+		}
+	}
+
+	// id 和 slug 不随修改变化
+	plant.ID, plant.Slug = s.plants[idx].ID, s.plants[idx].Slug
+
+	p := *plant
+	pls := slices.Clone(s.plants)
+	pls[idx] = &p
+	if err := s.flush(pls); err != nil {
+		return err
+	}
+
+	s.plants = pls
+	return nil
+}
+
+func (s *jsonStore) Modify(key string, fn func(plant *Plant) error) (*Plant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx, err := s.index(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plant := s.plants[idx].clone()
+	if err := fn(plant); err != nil {
+		return nil, err
+	}
+
+	for i, p := range s.plants {
+		if i != idx && duplicate(p, plant) {
+			return nil, errPlantExists
+		}
+	}
+	plant.ID, plant.Slug = s.plants[idx].ID, s.plants[idx].Slug
+
+	pls := slices.Clone(s.plants)
+	pls[idx] = plant
+	if err := s.flush(pls); err != nil {
+		return nil, err
+	}
+
+	s.plants = pls
+	return plant.clone(), nil
+}
+
+func (s *jsonStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx, err := s.index(key)
+	if err != nil {
+		return err
+	}
+
+	pls := slices.Delete(slices.Clone(s.plants), idx, idx+1)
+	if err := s.flush(pls); err != nil {
+		return err
+	}
+
+	s.plants = pls
+	return nil
+}
+
+func (s *jsonStore) Close() error {
+	return nil
+}
+
+// flush 原子写入, 替换前先备份旧文件, 任一步失败原文件都保持完整
+func (s *jsonStore) flush(pls []*Plant) error {
+	log.Println("flushing plants to file")
+
+	data, err := json.MarshalIndent(pls, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path, data, func() {
+		if err := s.backup(); err != nil {
+			log.Println("failed to backup plant file:", err)
+		}
+	})
+}
+
+// writeFileAtomic 写临时文件 -> fsync -> rename 覆盖, beforeRename 非空时在覆盖前调用
+func writeFileAtomic(path string, data []byte, beforeRename func()) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // rename 成功后为空操作
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to chmod file: %w", err)
+	}
+
+	if beforeRename != nil {
+		beforeRename()
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+
+	// 同步目录项, 确保 rename 本身落盘
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+// backup 滚动保留历史版本: plants.json.1 为最近一次, plants.json.N 为最旧
+func (s *jsonStore) backup() error {
+	if s.backups <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(s.path); os.IsNotExist(err) {
+		return nil
+	}
+
+	for i := s.backups - 1; i >= 1; i-- {
+		src := fmt.Sprintf("%s.%d", s.path, i)
+		if _, err := os.Stat(src); err == nil {
+			if err := os.Rename(src, fmt.Sprintf("%s.%d", s.path, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 硬链接当前文件, 随后 rename 替换 s.path 不会影响备份内容
+	dst := s.path + ".1"
+	os.Remove(dst)
+	if err := os.Link(s.path, dst); err == nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(dst, data, 0644)
+}
+
+// sqliteStore 使用内嵌的纯 Go sqlite, 每条记录单独存储, 增删改无需重写整个目录
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func openSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite: %w", err)
+	}
+
+	// sqlite 同一时间只允许一个写连接
+	db.SetMaxOpenConns(1)
+
+	stmts := []string{
+		`PRAGMA journal_mode = WAL`,
+		`PRAGMA busy_timeout = 5000`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to init sqlite: %w", err)
+		}
+	}
+
+	s := &sqliteStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate sqlite: %w", err)
+	}
+
+	return s, nil
+}
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS plants (
+	id     TEXT NOT NULL PRIMARY KEY,
+	slug   TEXT NOT NULL UNIQUE,
+	cnname TEXT NOT NULL,
+	enname TEXT NOT NULL DEFAULT '',
+	data   TEXT NOT NULL
+)`
+
+// migrate 建表, 并将旧版本以 cnname 为主键的表迁移为以 id 为主键
+func (s *sqliteStore) migrate() error {
+	var legacy int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'plants' AND sql NOT LIKE '%slug%'`).Scan(&legacy)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old []*Plant
+	if legacy > 0 {
+		rows, err := tx.Query(`SELECT data FROM plants ORDER BY rowid`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var data string
+			var plant Plant
+			if err := rows.Scan(&data); err != nil {
+				rows.Close()
+				return err
+			}
+			if err := json.Unmarshal([]byte(data), &plant); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to unmarshal json: %w", err)
+			}
+			old = append(old, &plant)
+		}
+		rows.Close()
+
+		if _, err := tx.Exec(`DROP TABLE plants`); err != nil {
+			return err
+		}
+	}
+
+	for _, stmt := range []string{
+		sqliteSchema,
+		`CREATE INDEX IF NOT EXISTS idx_plants_cnname ON plants (cnname)`,
+		`CREATE INDEX IF NOT EXISTS idx_plants_enname ON plants (enname)`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	slugs := map[string]bool{}
+	for _, p := range old {
+		assignID(p, func(slug string) bool { return slugs[slug] })
+		slugs[p.Slug] = true
+		if err := insertPlant(tx, p); err != nil {
+			return err
+		}
+	}
+	if len(old) > 0 {
+		log.Printf("assigned ids to %d plants in sqlite\n", len(old))
+	}
+
+	return tx.Commit()
+}
+
+type sqlExecer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func insertPlant(db sqlExecer, plant *Plant) error {
+	data, err := json.Marshal(plant)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`INSERT INTO plants (id, slug, cnname, enname, data) VALUES (?, ?, ?, ?, ?)`,
+		plant.ID, plant.Slug, plant.Cnname, plant.Enname, string(data))
+	return err
+}
+
+func slugTaken(db sqlExecer, slug string) bool {
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM plants WHERE slug = ?`, slug).Scan(&n)
+	return n > 0
+}
+
+// resolve 按 id/slug, 中文名, 英文名的顺序查找 key 对应的 id
+func resolve(db sqlExecer, key string) (string, error) {
+	if key == "" {
+		return "", errPlantNotFound
+	}
+
+	for _, query := range []string{
+		`SELECT id FROM plants WHERE id = ? OR slug = ?`,
+		`SELECT id FROM plants WHERE cnname = ? AND ? != ''`,
+		`SELECT id FROM plants WHERE enname = ? AND ? != ''`,
+	} {
+		rows, err := db.Query(query+` LIMIT 2`, key, key)
+		if err != nil {
+			return "", err
+		}
+
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return "", err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+
+		switch len(ids) {
+		case 0:
+			continue
+		case 1:
+			return ids[0], nil
+		default:
+			return "", errPlantAmbiguous
+		}
+	}
+
+	return "", errPlantNotFound
+}
+
+func (s *sqliteStore) Get(key string) (*Plant, error) {
+	id, err := resolve(s.db, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var data string
+	if err := s.db.QueryRow(`SELECT data FROM plants WHERE id = ?`, id).Scan(&data); err != nil {
+		return nil, err
+	}
+
+	var plant Plant
+	if err := json.Unmarshal([]byte(data), &plant); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal json: %w", err)
+	}
+
+	return &plant, nil
+}
+
+func (s *sqliteStore) List() ([]*Plant, error) {
+	rows, err := s.db.Query(`SELECT data FROM plants ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pls := []*Plant{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var plant Plant
+		if err := json.Unmarshal([]byte(data), &plant); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal json: %w", err)
+		}
+		pls = append(pls, &plant)
+	}
+
+	return pls, rows.Err()
+}
+
+func (s *sqliteStore) Put(plant *Plant) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var n int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM plants WHERE id = ? OR (cnname = ? AND enname = ?)`, plant.ID, plant.Cnname, plant.Enname).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return errPlantExists
+	}
+
+	assignID(plant, func(slug string) bool { return slugTaken(tx, slug) })
+	if err := insertPlant(tx, plant); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Update(key string, plant *Plant) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := resolve(tx, key)
+	if err != nil {
+		return err
+	}
+
+	var n int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM plants WHERE id != ? AND cnname = ? AND enname = ?`, id, plant.Cnname, plant.Enname).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return errPlantExists
+	}
+
+	// id 和 slug 不随修改变化
+	if err := tx.QueryRow(`SELECT id, slug FROM plants WHERE id = ?`, id).Scan(&plant.ID, &plant.Slug); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(plant)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE plants SET cnname = ?, enname = ?, data = ? WHERE id = ?`, plant.Cnname, plant.Enname, string(data), id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Modify(key string, fn func(plant *Plant) error) (*Plant, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	id, err := resolve(tx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var data, slug string
+	if err := tx.QueryRow(`SELECT data, slug FROM plants WHERE id = ?`, id).Scan(&data, &slug); err != nil {
+		return nil, err
+	}
+	var plant Plant
+	if err := json.Unmarshal([]byte(data), &plant); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal json: %w", err)
+	}
+
+	if err := fn(&plant); err != nil {
+		return nil, err
+	}
+
+	var n int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM plants WHERE id != ? AND cnname = ? AND enname = ?`, id, plant.Cnname, plant.Enname).Scan(&n)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		return nil, errPlantExists
+	}
+	plant.ID, plant.Slug = id, slug
+
+	updated, err := json.Marshal(&plant)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE plants SET cnname = ?, enname = ?, data = ? WHERE id = ?`, plant.Cnname, plant.Enname, string(updated), id); err != nil {
+		return nil, err
+	}
+
+	return &plant, tx.Commit()
+}
+
+func (s *sqliteStore) Delete(key string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := resolve(tx, key)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM plants WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+// importPlants 将 json 文件中的植物导入到空的存储中, 便于从 json 迁移到 sqlite; 只读取 json 文件, 缺少的 id 由目标存储生成
+func importPlants(dst PlantStore, path string) error {
+	pls, err := dst.List()
+	if err != nil || len(pls) > 0 {
+		return err
+	}
+
+	src, err := readPlantsFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, p := range src {
+		if err := dst.Put(p); err != nil && !errors.Is(err, errPlantExists) {
+			return err
+		}
+	}
+
+	if len(src) > 0 {
+		log.Printf("imported %d plants from %s\n", len(src), path)
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// lookupProgress 查询过程中的回调, 均可为空
+type lookupProgress struct {
+	Request func(attempt int)            // 向大模型发出第 attempt 次请求
+	Delta   func(delta string)           // 收到一段流式回答
+	Invalid func(attempt int, err error) // 第 attempt 次回答未通过校验, 即将要求模型修正
+}
+
+// eventStream 将查询进度逐条推送给客户端, 支持 SSE 和 NDJSON 两种格式
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	ndjson  bool
+}
+
+// newEventStream 默认使用 SSE, ?format=ndjson 或 Accept: application/x-ndjson 时使用 NDJSON
+func newEventStream(w http.ResponseWriter, r *http.Request) (*eventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming unsupported")
+	}
+
+	s := &eventStream{w: w, flusher: flusher}
+	s.ndjson = r.URL.Query().Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+
+	if s.ndjson {
+		w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // 避免 nginx 缓冲
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return s, nil
+}
+
+// Send 推送一个事件, NDJSON 格式为 {"event": ..., "data": ...}
+func (s *eventStream) Send(event string, data any) error {
+	var err error
+	if s.ndjson {
+		err = json.NewEncoder(s.w).Encode(map[string]any{"event": event, "data": data})
+	} else {
+		var b []byte
+		if b, err = json.Marshal(data); err == nil {
+			_, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, b)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	s.flusher.Flush()
+	return nil
+}
+
+// streamLookupHandler 流式查询植物, 依次推送 start, cache, llm_request, token, invalid, plant, image, done 或 failed 事件
+func streamLookupHandler(w http.ResponseWriter, r *http.Request) {
+	pname := strings.TrimSpace(r.PathValue("name"))
+	if pname == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "please input plant name", nil)
+		return
+	}
+
+	if refresh, _ := strconv.ParseBool(r.URL.Query().Get("refresh")); refresh {
+		cache.Invalidate(pname)
+	}
+
+	es, err := newEventStream(w, r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error(), nil)
+		return
+	}
+
+	send := func(event string, data any) {
+		if err := es.Send(event, data); err != nil {
+			log.Printf("failed to send %s event for %s: %v\n", event, pname, err)
+		}
+	}
+
+	send("start", map[string]string{"name": pname})
+
+	if pls, ok := cache.Get(pname); ok {
+		send("cache", map[string]bool{"hit": true})
+		for i, p := range pls {
+			send("plant", map[string]any{"index": i, "plant": p})
+			for _, image := range p.Images {
+				send("image", map[string]any{"index": i, "url": image})
+			}
+		}
+		send("done", map[string]any{"plants": pls})
+		return
+	}
+
+	pls, err := reqAI(r.Context(), pname, &lookupProgress{
+		Request: func(attempt int) {
+			send("llm_request", map[string]any{"attempt": attempt, "provider": llm.Name()})
+		},
+		Delta: func(delta string) {
+			send("token", map[string]string{"delta": delta})
+		},
+		Invalid: func(attempt int, err error) {
+			send("invalid", map[string]any{"attempt": attempt, "error": lookupError(err)})
+		},
+	})
+	if err != nil {
+		log.Println("request ai error:", err)
+		// 不使用 error 作为事件名, 避免与浏览器 EventSource 的连接错误事件混淆
+		send("failed", lookupError(err))
+		return
+	}
+	if len(pls) == 0 {
+		send("failed", apiError{Code: "not_found", Message: "no plant found", Details: map[string]string{"name": pname}})
+		return
+	}
+
+	// 先推送文字信息, 再依次查询各个图片来源, 每找到一张图片推送一次
+	enrichPlants(r.Context(), pls, false)
+	for i, p := range pls {
+		send("plant", map[string]any{"index": i, "plant": p})
+	}
+	for i, p := range pls {
+		if r.Context().Err() != nil {
+			return
+		}
+		p.Images = fetchPlantImages(r.Context(), p, func(image string) {
+			send("image", map[string]any{"index": i, "url": image})
+		})
+	}
+
+	cache.Put(pname, pls)
+	send("done", map[string]any{"plants": pls})
+}